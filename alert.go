@@ -0,0 +1,64 @@
+package iotawatt
+
+import (
+	"math"
+	"regexp"
+)
+
+// alertClassName matches input names usable in an alert css class.
+var alertClassName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// alerting returns if an input is in alert given its previous state,
+// its latest value, the alert value and the hysteresis. An input in
+// alert stays in alert until it falls the hysteresis below the alert
+// value. Missing values keep the previous state.
+func alerting(on bool, v, limit, hysteresis float64) bool {
+	switch {
+	case math.IsNaN(v):
+		return on
+	case on:
+		return v > limit-hysteresis
+	default:
+		return v > limit
+	}
+}
+
+// updateAlerts evaluates the alert of each input against its latest
+// value, toggling the "alert-<name>" class of the inputs that changed
+// and the "alert" class while any input is in alert.
+func (m *Module) updateAlerts(raw [][]float64) error {
+	const docSelector = "document.querySelector('#%s .iotawatt')"
+
+	if len(raw) == 0 {
+		return nil
+	}
+	if m.alerts == nil {
+		m.alerts = make([]bool, len(m.inputs))
+	}
+
+	row := raw[len(raw)-1]
+	var inAlert bool
+	for i, in := range m.inputs {
+		if in.Alert == 0 {
+			continue
+		}
+		v := row[i+1]
+		on := alerting(m.alerts[i], v, in.Alert, in.AlertHysteresis)
+		inAlert = inAlert || on
+		if on == m.alerts[i] {
+			continue
+		}
+		m.alerts[i] = on
+
+		if on {
+			m.log.Info("Input alert raised", m.logFields("updateAlerts", "input", in.Name, "value", v)...)
+		} else {
+			m.log.Info("Input alert cleared", m.logFields("updateAlerts", "input", in.Name, "value", v)...)
+		}
+		if _, err := m.eval(docSelector+".classList.toggle('alert-%s', %t)", m.name, in.Name, on); err != nil {
+			return err
+		}
+	}
+	_, err := m.eval(docSelector+".classList.toggle('alert', %t)", m.name, inAlert)
+	return err
+}
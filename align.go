@@ -0,0 +1,90 @@
+package iotawatt
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// parseGroup parses a fixed query group, e.g. "5m", into its count
+// and unit, one of "s", "m", "h", "d", "w", "M" or "y".
+func parseGroup(group string) (int, string, error) {
+	if len(group) < 2 {
+		return 0, "", fmt.Errorf("unsupported group %q", group)
+	}
+	unit := group[len(group)-1:]
+	switch unit {
+	case "s", "m", "h", "d", "w", "M", "y":
+	default:
+		return 0, "", fmt.Errorf("unsupported group %q", group)
+	}
+	n, err := strconv.Atoi(group[:len(group)-1])
+	if err != nil || n <= 0 {
+		return 0, "", fmt.Errorf("unsupported group %q", group)
+	}
+	return n, unit, nil
+}
+
+// floorGroup rounds t down to the boundary of the group in the
+// location of t. Groups of days and longer are aligned to local
+// midnight, weeks to Sunday, months to the first of the month and
+// years to the first of January.
+func floorGroup(t time.Time, n int, unit string) time.Time {
+	y, mon, d := t.Date()
+	switch unit {
+	case "s":
+		return truncateLocal(t, time.Duration(n)*time.Second)
+	case "m":
+		return truncateLocal(t, time.Duration(n)*time.Minute)
+	case "h":
+		return truncateLocal(t, time.Duration(n)*time.Hour)
+	case "d":
+		return time.Date(y, mon, d-(t.YearDay()-1)%n, 0, 0, 0, 0, t.Location())
+	case "w":
+		return time.Date(y, mon, d-int(t.Weekday()), 0, 0, 0, 0, t.Location())
+	case "M":
+		return time.Date(y, mon-time.Month((int(mon)-1)%n), 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y-y%n, time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// truncateLocal rounds t down to a multiple of size in the location
+// of t. Truncate works on absolute time, so t is shifted by its zone
+// offset to align to local boundaries, e.g. whole hours in zones with
+// a half hour offset.
+func truncateLocal(t time.Time, size time.Duration) time.Time {
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(size).Add(-shift)
+}
+
+// alignedWindow returns the query window of the given length ending at
+// now, with both ends rounded down to the group boundary so the first
+// and last groups are whole.
+func alignedWindow(now time.Time, window time.Duration, n int, unit string) (begin, end time.Time) {
+	end = floorGroup(now, n, unit)
+	return floorGroup(end.Add(-window), n, unit), end
+}
+
+// alignedQuery returns the query values of the device with the begin
+// and end aligned to the group boundaries in the configured location.
+func (m *Module) alignedQuery(now time.Time) func(*device) url.Values {
+	n, unit, err := parseGroup(m.cfg.QueryParams["group"])
+	if err != nil {
+		// Validated with the config.
+		return nil
+	}
+	begin, end := alignedWindow(now.In(m.loc), m.cfg.Window, n, unit)
+
+	return func(d *device) url.Values {
+		vals := make(url.Values, len(d.qryVals))
+		for k, v := range d.qryVals {
+			vals[k] = v
+		}
+		vals.Set("begin", strconv.FormatInt(begin.Unix(), 10))
+		vals.Set("end", strconv.FormatInt(end.Unix(), 10))
+		return vals
+	}
+}
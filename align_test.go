@@ -0,0 +1,80 @@
+package iotawatt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlignedWindow(t *testing.T) {
+	utc := time.UTC
+	india, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		window    time.Duration
+		n         int
+		unit      string
+		wantBegin time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "minutes",
+			now:       time.Date(2021, 3, 4, 10, 17, 42, 0, utc),
+			window:    time.Hour,
+			n:         5,
+			unit:      "m",
+			wantBegin: time.Date(2021, 3, 4, 9, 15, 0, 0, utc),
+			wantEnd:   time.Date(2021, 3, 4, 10, 15, 0, 0, utc),
+		},
+		{
+			name:      "hours in a half hour zone",
+			now:       time.Date(2021, 3, 4, 10, 17, 0, 0, india),
+			window:    3 * time.Hour,
+			n:         1,
+			unit:      "h",
+			wantBegin: time.Date(2021, 3, 4, 7, 0, 0, 0, india),
+			wantEnd:   time.Date(2021, 3, 4, 10, 0, 0, 0, india),
+		},
+		{
+			name:      "days",
+			now:       time.Date(2021, 3, 4, 10, 17, 0, 0, utc),
+			window:    48 * time.Hour,
+			n:         1,
+			unit:      "d",
+			wantBegin: time.Date(2021, 3, 2, 0, 0, 0, 0, utc),
+			wantEnd:   time.Date(2021, 3, 4, 0, 0, 0, 0, utc),
+		},
+		{
+			name:      "weeks",
+			now:       time.Date(2021, 3, 4, 10, 17, 0, 0, utc),
+			window:    7 * 24 * time.Hour,
+			n:         1,
+			unit:      "w",
+			wantBegin: time.Date(2021, 2, 21, 0, 0, 0, 0, utc),
+			wantEnd:   time.Date(2021, 2, 28, 0, 0, 0, 0, utc),
+		},
+		{
+			name:      "months",
+			now:       time.Date(2021, 3, 4, 10, 17, 0, 0, utc),
+			window:    28 * 24 * time.Hour,
+			n:         1,
+			unit:      "M",
+			wantBegin: time.Date(2021, 2, 1, 0, 0, 0, 0, utc),
+			wantEnd:   time.Date(2021, 3, 1, 0, 0, 0, 0, utc),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			begin, end := alignedWindow(test.now, test.window, test.n, test.unit)
+
+			assert.True(t, test.wantBegin.Equal(begin), "begin %s", begin)
+			assert.True(t, test.wantEnd.Equal(end), "end %s", end)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package iotawatt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// evalBatch collects the ui scripts of a poll so they are evaluated
+// in a single call.
+type evalBatch struct {
+	scripts []string
+}
+
+// eval evaluates the script in the ui, or adds it to the batch when
+// one is started. Batched scripts always return a nil result. The
+// batch is only used on the poll goroutine; exported methods called
+// from other goroutines evaluate directly with m.ui.Eval.
+func (m *Module) eval(cmd string, args ...interface{}) (interface{}, error) {
+	if m.batch != nil {
+		m.batch.scripts = append(m.batch.scripts, fmt.Sprintf(cmd, args...))
+		return nil, nil
+	}
+	res, err := m.ui.Eval(cmd, args...)
+	if err != nil {
+		m.uiFailed = true
+	}
+	return res, err
+}
+
+// startBatch starts batching scripts when enabled.
+func (m *Module) startBatch() {
+	if m.cfg.BatchEvals {
+		m.batch = &evalBatch{}
+	}
+}
+
+// flushBatch evaluates the batched scripts in a single call. Each
+// script is evaluated even if an earlier one fails, and the failed
+// scripts are reported in the returned error.
+func (m *Module) flushBatch() error {
+	b := m.batch
+	m.batch = nil
+	if b == nil || len(b.scripts) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("(function () { const failed = [];")
+	for i, script := range b.scripts {
+		sb.WriteString(" try { ")
+		sb.WriteString(script)
+		sb.WriteString(" } catch (e) { failed.push([" + strconv.Itoa(i) + ", String(e)]); }")
+	}
+	sb.WriteString(" return failed; })()")
+
+	res, err := m.ui.Eval("%s", sb.String())
+	if err != nil {
+		m.uiFailed = true
+		return err
+	}
+	failed, ok := res.([]interface{})
+	if !ok || len(failed) == 0 {
+		return nil
+	}
+	m.uiFailed = true
+
+	msgs := make([]string, 0, len(failed))
+	for _, f := range failed {
+		pair, ok := f.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		idx, ok := pair[0].(float64)
+		if !ok || int(idx) < 0 || int(idx) >= len(b.scripts) {
+			continue
+		}
+		msgs = append(msgs, fmt.Sprintf("%s: %v", scriptSummary(b.scripts[int(idx)]), pair[1]))
+	}
+	return fmt.Errorf("batched ui update failed: %s", strings.Join(msgs, "; "))
+}
+
+// scriptSummary returns the start of the script to identify it in errors.
+func scriptSummary(script string) string {
+	const max = 60
+
+	if len(script) <= max {
+		return script
+	}
+	return script[:max] + "..."
+}
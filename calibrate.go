@@ -0,0 +1,107 @@
+package iotawatt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const apiStatusPath = "status"
+
+type deviceStatus struct {
+	Device struct {
+		Version string `json:"version"`
+	} `json:"device"`
+	Datalogs []struct {
+		ID       string `json:"id"`
+		Interval int    `json:"interval"`
+	} `json:"datalogs"`
+}
+
+// readStatus reads the status of the main device with the given query,
+// within the request rate limit and concurrency of the polls.
+func (m *Module) readStatus(ctx context.Context, query string) (deviceStatus, error) {
+	var status deviceStatus
+	if err := m.wait(ctx); err != nil {
+		return status, fmt.Errorf("request rate limited: %w", err)
+	}
+	release, err := m.acquire(ctx)
+	if err != nil {
+		return status, fmt.Errorf("request queued: %w", err)
+	}
+	defer release()
+
+	u := endpointURL(m.devices[0].baseURLs[0], apiStatusPath)
+	u.RawQuery = query
+
+	req, err := m.newRequest(ctx, u)
+	if err != nil {
+		return status, fmt.Errorf("could not create request: %w", err)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return status, fmt.Errorf("could not request status: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, fmt.Errorf("could not parse status: %w", err)
+	}
+	return status, nil
+}
+
+// datalogInterval returns the interval of the current datalog of the
+// main device, which new data is recorded at.
+func (m *Module) datalogInterval(ctx context.Context) (time.Duration, error) {
+	status, err := m.readStatus(ctx, "datalogs=yes")
+	if err != nil {
+		return 0, err
+	}
+	for _, log := range status.Datalogs {
+		if log.ID == "Current" && log.Interval > 0 {
+			return time.Duration(log.Interval) * time.Second, nil
+		}
+	}
+	return 0, fmt.Errorf("status has no current datalog interval")
+}
+
+// tuneInterval raises the poll interval to the datalog interval of
+// the device, as polling faster never returns new data.
+func (m *Module) tuneInterval(ctx context.Context) error {
+	interval, err := m.datalogInterval(ctx)
+	if err != nil {
+		return err
+	}
+	if m.interval < interval {
+		m.log.Info("Interval is shorter than the device datalog interval, using the datalog interval", m.logFields("tuneInterval", "interval", m.interval.String(), "datalogInterval", interval.String())...)
+		m.interval = interval
+	}
+	return nil
+}
+
+// newScales returns the per-column scale factors for the configured inputs.
+func newScales(inputs []Input, scale map[string]float64) []float64 {
+	scales := make([]float64, len(inputs)+1)
+	for i, in := range inputs {
+		scales[i+1] = 1
+		if s, ok := scale[in.Name]; ok {
+			scales[i+1] = s
+		}
+	}
+	return scales
+}
+
+// applyScales scales the response values in place.
+func (m *Module) applyScales(raw [][]float64) {
+	for _, row := range raw {
+		for j := 1; j < len(row) && j < len(m.scales); j++ {
+			row[j] *= m.scales[j]
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package iotawatt
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// captureBuffer is the number of responses queued for writing before
+// further responses are dropped.
+const captureBuffer = 16
+
+// capture writes the raw device responses to a size capped file,
+// rotating the previous captures to a ".1" file, or a gzipped ".1.gz"
+// file when compressing.
+type capture struct {
+	path     string
+	maxSize  int64
+	compress bool
+	entries  chan []byte
+
+	log  func(err error)
+	size int64
+}
+
+func newCapture(path string, maxSize int64, compress bool, log func(err error)) *capture {
+	c := &capture{
+		path:     path,
+		maxSize:  maxSize,
+		compress: compress,
+		entries:  make(chan []byte, captureBuffer),
+		log:      log,
+	}
+	if fi, err := os.Stat(path); err == nil {
+		c.size = fi.Size()
+	}
+	return c
+}
+
+// add queues a response for writing without blocking. Responses are
+// dropped when the queue is full.
+func (c *capture) add(u string, status int, body []byte) {
+	entry := []byte(fmt.Sprintf("# %s %s %d\n", time.Now().Format(time.RFC3339), u, status))
+	entry = append(entry, body...)
+	entry = append(entry, '\n')
+
+	select {
+	case c.entries <- entry:
+	default:
+	}
+}
+
+// run writes the queued responses until done is closed.
+func (c *capture) run(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case entry := <-c.entries:
+			if err := c.write(entry); err != nil {
+				c.log(err)
+			}
+		}
+	}
+}
+
+func (c *capture) write(entry []byte) error {
+	if c.size > 0 && c.size+int64(len(entry)) > c.maxSize {
+		if err := os.Rename(c.path, c.path+".1"); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not rotate capture: %w", err)
+		}
+		c.size = 0
+
+		if c.compress {
+			if err := compressFile(c.path + ".1"); err != nil {
+				return fmt.Errorf("could not compress capture: %w", err)
+			}
+		}
+	}
+
+	f, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open capture: %w", err)
+	}
+	n, err := f.Write(entry)
+	c.size += int64(n)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// A full disk leaves a partial entry, which the next
+		// rotation discards.
+		return fmt.Errorf("could not write capture: %w", err)
+	}
+	return nil
+}
+
+// compressFile gzips the file at path to path with a ".gz" suffix,
+// removing the original once the compressed file is complete.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
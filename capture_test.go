@@ -0,0 +1,79 @@
+package iotawatt
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapture_WritesAndRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.log")
+	c := newCapture(path, 20, false, func(err error) { t.Error(err) })
+
+	err := c.write([]byte("first entry\n"))
+	require.NoError(t, err)
+	err = c.write([]byte("second entry\n"))
+	require.NoError(t, err)
+
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second entry\n", string(got))
+	rotated, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "first entry\n", string(rotated))
+}
+
+func TestCapture_RunWritesQueued(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.log")
+	c := newCapture(path, 1<<20, false, func(err error) { t.Error(err) })
+	done := make(chan struct{})
+	defer close(done)
+	go c.run(done)
+
+	c.add("http://iotawatt.local/query", 200, []byte(`[[1614852000,500]]`))
+
+	assert.Eventually(t, func() bool {
+		b, err := os.ReadFile(path)
+		return err == nil && len(b) > 0
+	}, time.Second, 10*time.Millisecond)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Regexp(t, `^# \S+ http://iotawatt.local/query 200\n\[\[1614852000,500\]\]\n$`, string(b))
+}
+
+func TestCapture_AddDoesNotBlock(t *testing.T) {
+	c := newCapture(filepath.Join(t.TempDir(), "capture.log"), 1<<20, false, func(err error) { t.Error(err) })
+
+	for i := 0; i < 2*captureBuffer; i++ {
+		c.add("http://iotawatt.local/query", 200, []byte("[]"))
+	}
+
+	assert.Len(t, c.entries, captureBuffer)
+}
+
+func TestCapture_CompressesRotated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.log")
+	c := newCapture(path, 20, true, func(err error) { t.Error(err) })
+
+	err := c.write([]byte("first entry\n"))
+	require.NoError(t, err)
+	err = c.write([]byte("second entry\n"))
+	require.NoError(t, err)
+
+	_, err = os.Stat(path + ".1")
+	assert.True(t, os.IsNotExist(err))
+	f, err := os.Open(path + ".1.gz")
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "first entry\n", string(got))
+}
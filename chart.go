@@ -0,0 +1,173 @@
+package iotawatt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ReferenceLine is a static line drawn on the chart at a value.
+type ReferenceLine struct {
+	Value float64 `yaml:"value"`
+	Label string  `yaml:"label"`
+	Color string  `yaml:"color"`
+}
+
+// chartOptions are the chart options applied when the chart is loaded.
+type chartOptions struct {
+	PlotLines       []plotLine `json:"plotLines,omitempty"`
+	TimeFormat      string     `json:"timeFormat,omitempty"`
+	TickInterval    float64    `json:"tickInterval,omitempty"`
+	TimestampMillis bool       `json:"timestampMillis,omitempty"`
+	Stacked         bool       `json:"stacked,omitempty"`
+	ChartType       string     `json:"chartType,omitempty"`
+	LineWidth       float64    `json:"lineWidth,omitempty"`
+	MarkerRadius    float64    `json:"markerRadius,omitempty"`
+	Legend          string     `json:"legend,omitempty"`
+}
+
+// defaultLineWidth is the line width of the bundled chart.
+const defaultLineWidth = 1
+
+// chartTypes are the supported chart types and their chart library
+// series types. The default spline type needs no options.
+var chartTypes = map[string]string{
+	"spline": "",
+	"line":   "line",
+	"area":   "area",
+	"bar":    "column",
+}
+
+type plotLine struct {
+	Value  float64       `json:"value"`
+	Color  string        `json:"color"`
+	Width  int           `json:"width"`
+	ZIndex int           `json:"zIndex"`
+	Label  plotLineLabel `json:"label"`
+}
+
+type plotLineLabel struct {
+	Text  string            `json:"text"`
+	Style map[string]string `json:"style"`
+}
+
+// newChartOptions returns the chart options for the configuration.
+func newChartOptions(cfg *Config) chartOptions {
+	var opts chartOptions
+	for _, line := range cfg.ReferenceLines {
+		color := line.Color
+		if color == "" {
+			color = "#666666"
+		}
+		opts.PlotLines = append(opts.PlotLines, plotLine{
+			Value:  line.Value,
+			Color:  color,
+			Width:  1,
+			ZIndex: 3,
+			Label: plotLineLabel{
+				Text:  line.Label,
+				Style: map[string]string{"color": color},
+			},
+		})
+	}
+
+	opts.TimeFormat = cfg.TimeFormat
+	if cfg.TimeTickInterval > 0 {
+		opts.TickInterval = cfg.TimeTickInterval.Seconds()
+		if cfg.TimestampMillis {
+			opts.TickInterval *= 1000
+		}
+	}
+	opts.TimestampMillis = cfg.TimestampMillis
+	opts.Stacked = cfg.Stacked
+	opts.ChartType = chartTypes[cfg.ChartType]
+	if cfg.LineWidth != defaultLineWidth {
+		opts.LineWidth = cfg.LineWidth
+	}
+	if cfg.Markers {
+		opts.MarkerRadius = cfg.MarkerRadius
+	}
+	return opts
+}
+
+func (o chartOptions) empty() bool {
+	return len(o.PlotLines) == 0 && o.TimeFormat == "" && o.TickInterval == 0 && !o.Stacked &&
+		o.ChartType == "" && o.LineWidth == 0 && o.MarkerRadius == 0 && o.Legend == ""
+}
+
+// validateTimeFormat verifies the chart time format only uses
+// the supported date format specifiers.
+func validateTimeFormat(format string) error {
+	const specifiers = "aAdewbBmyYHkIlMpPSL%"
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i == len(format) || !strings.ContainsRune(specifiers, rune(format[i])) {
+			return fmt.Errorf("unsupported format specifier at position %d", i)
+		}
+	}
+	return nil
+}
+
+// renderChartOptions pushes the chart options to the ui, reloading
+// the chart if it is already loaded.
+func (m *Module) renderChartOptions() error {
+	opts := newChartOptions(m.cfg)
+	if m.cfg.Legend {
+		// The legend calls the function bound to set the visibility.
+		opts.Legend = m.legendFunction()
+	}
+	if opts.empty() {
+		return nil
+	}
+
+	b, err := json.Marshal(opts)
+	if err != nil {
+		return fmt.Errorf("iotawatt: could not encode chart options: %w", err)
+	}
+	if _, err = m.ui.Eval("%s = %s; %s()", m.jsName("iotaWattOptions"), string(b), m.jsName(m.cfg.ReloadFunction)); err != nil {
+		return fmt.Errorf("iotawatt: could not load chart options: %w", err)
+	}
+	return nil
+}
+
+// chartReady returns if the chart library is loaded and the chart
+// created. The library is loaded from a CDN so may still be loading on
+// the first poll; it is only reported missing on consecutive polls.
+// Once ready, it is only checked again after a ui update failed.
+func (m *Module) chartReady() bool {
+	if m.chartOK {
+		return true
+	}
+
+	res, err := m.ui.Eval("typeof Highcharts === 'undefined' ? 'missing' : (typeof %[1]s !== 'undefined' && %[1]s ? 'ready' : 'loading')", m.jsName(m.cfg.ChartVariable))
+	if err != nil {
+		m.log.Error("Could not check chart", m.logFields("chartReady", "error", err)...)
+		return false
+	}
+
+	switch res {
+	case "ready":
+		if m.chartMisses > 1 {
+			m.log.Info("Chart library loaded", m.logFields("chartReady")...)
+		}
+		m.chartMisses = 0
+		m.chartOK = true
+		return true
+	case "missing":
+		m.chartMisses++
+		if m.chartMisses == 2 {
+			m.log.Info("Chart library is not loaded, only the current value is updated", m.logFields("chartReady")...)
+		}
+	}
+	return false
+}
+
+// currentQuery returns the javascript querying the current value
+// element, formatted with the module name.
+func (m *Module) currentQuery() string {
+	return "document.querySelector('#%s " + strings.ReplaceAll(m.cfg.CurrentSelector, "%", "%%") + "')"
+}
@@ -0,0 +1,262 @@
+package iotawatt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModule_RenderChartOptionsReferenceLines(t *testing.T) {
+	cfg := NewConfig()
+	cfg.ReferenceLines = []ReferenceLine{
+		{Value: 5000, Label: "Solar", Color: "#ff0000"},
+		{Value: 1200, Label: "Average"},
+	}
+	m, ui, _ := newTestModule(t, cfg)
+
+	err := m.renderChartOptions()
+
+	require.NoError(t, err)
+	want := `iotaWattOptions_test = {"plotLines":[` +
+		`{"value":5000,"color":"#ff0000","width":1,"zIndex":3,"label":{"text":"Solar","style":{"color":"#ff0000"}}},` +
+		`{"value":1200,"color":"#666666","width":1,"zIndex":3,"label":{"text":"Average","style":{"color":"#666666"}}}` +
+		`]}; reloadChart_test()`
+	assert.Equal(t, []string{want}, ui.scripts())
+}
+
+func TestModule_RenderChartOptionsDefault(t *testing.T) {
+	m, ui, _ := newTestModule(t, NewConfig())
+
+	err := m.renderChartOptions()
+
+	require.NoError(t, err)
+	assert.Empty(t, ui.scripts())
+}
+
+func TestModule_RenderChartOptionsTimeAxis(t *testing.T) {
+	tests := []struct {
+		name   string
+		millis bool
+		want   string
+	}{
+		{
+			name: "seconds",
+			want: `iotaWattOptions_test = {"timeFormat":"%H:%M","tickInterval":3600}; reloadChart_test()`,
+		},
+		{
+			name:   "millis",
+			millis: true,
+			want:   `iotaWattOptions_test = {"timeFormat":"%H:%M","tickInterval":3600000,"timestampMillis":true}; reloadChart_test()`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.TimeFormat = "%H:%M"
+			cfg.TimeTickInterval = time.Hour
+			cfg.TimestampMillis = test.millis
+			m, ui, _ := newTestModule(t, cfg)
+
+			err := m.renderChartOptions()
+
+			require.NoError(t, err)
+			assert.Equal(t, []string{test.want}, ui.scripts())
+		})
+	}
+}
+
+func TestValidateTimeFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "hours",
+			format:  "%H:%M",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "escaped percent",
+			format:  "%M:%S %%",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "unsupported specifier",
+			format:  "%H:%Q",
+			wantErr: require.Error,
+		},
+		{
+			name:    "trailing percent",
+			format:  "%H:%",
+			wantErr: require.Error,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateTimeFormat(test.format)
+
+			test.wantErr(t, err)
+		})
+	}
+}
+
+func TestModule_PollLegendLabelsHaveUnits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,500,230,100]]`, time.Now().Unix()/20*20)
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{
+		{Name: "main", Label: "Mains", Unit: "watts"},
+		{Name: "line", Label: "Line V", Unit: "volts"},
+		{Name: "other"},
+	}
+	m, ui, _ := newTestModule(t, cfg)
+
+	err := m.poll(context.Background())
+
+	require.NoError(t, err)
+	var series string
+	for _, script := range ui.scripts() {
+		if strings.HasPrefix(script, "iotaWattSeries_test = ") {
+			series = script
+		}
+	}
+	assert.Contains(t, series, `"name":"Mains (W)"`)
+	assert.Contains(t, series, `"name":"Line V (V)"`)
+	assert.Contains(t, series, `"name":"other"`)
+}
+
+func TestModule_RenderChartOptionsStacked(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Stacked = true
+	m, ui, _ := newTestModule(t, cfg)
+
+	err := m.renderChartOptions()
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{`iotaWattOptions_test = {"stacked":true}; reloadChart_test()`}, ui.scripts())
+}
+
+func TestModule_PollStackedWarnsNegative(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,500,-200]]`, time.Now().Unix()/20*20)
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{{Name: "main"}, {Name: "solar"}}
+	cfg.Stacked = true
+	m, ui, log := newTestModule(t, cfg)
+
+	err := m.poll(context.Background())
+	require.NoError(t, err)
+	err = m.poll(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Stacked series contain negative values, the stacked areas may be misleading"}, log.infos())
+	assert.True(t, ui.evaluated("0<sel>.3 kW"))
+}
+
+func TestModule_RenderChartOptionsChartType(t *testing.T) {
+	tests := []struct {
+		name      string
+		chartType string
+		want      []string
+	}{
+		{
+			name:      "spline",
+			chartType: "spline",
+			want:      nil,
+		},
+		{
+			name:      "line",
+			chartType: "line",
+			want:      []string{`iotaWattOptions_test = {"chartType":"line"}; reloadChart_test()`},
+		},
+		{
+			name:      "area",
+			chartType: "area",
+			want:      []string{`iotaWattOptions_test = {"chartType":"area"}; reloadChart_test()`},
+		},
+		{
+			name:      "bar",
+			chartType: "bar",
+			want:      []string{`iotaWattOptions_test = {"chartType":"column"}; reloadChart_test()`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.ChartType = test.chartType
+			m, ui, _ := newTestModule(t, cfg)
+
+			err := m.renderChartOptions()
+
+			require.NoError(t, err)
+			assert.Equal(t, test.want, ui.scripts())
+		})
+	}
+}
+
+func TestModule_RenderChartOptionsStyle(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  func(cfg *Config)
+		want []string
+	}{
+		{
+			name: "line width",
+			cfg:  func(cfg *Config) { cfg.LineWidth = 3 },
+			want: []string{`iotaWattOptions_test = {"lineWidth":3}; reloadChart_test()`},
+		},
+		{
+			name: "markers",
+			cfg: func(cfg *Config) {
+				cfg.Markers = true
+				cfg.MarkerRadius = 4
+			},
+			want: []string{`iotaWattOptions_test = {"markerRadius":4}; reloadChart_test()`},
+		},
+		{
+			name: "marker radius without markers",
+			cfg:  func(cfg *Config) { cfg.MarkerRadius = 4 },
+			want: nil,
+		},
+		{
+			name: "line width and markers",
+			cfg: func(cfg *Config) {
+				cfg.LineWidth = 2.5
+				cfg.Markers = true
+			},
+			want: []string{`iotaWattOptions_test = {"lineWidth":2.5,"markerRadius":2}; reloadChart_test()`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			test.cfg(cfg)
+			m, ui, _ := newTestModule(t, cfg)
+
+			err := m.renderChartOptions()
+
+			require.NoError(t, err)
+			assert.Equal(t, test.want, ui.scripts())
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package iotawatt
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ColorStop is a color the current value is displayed
+// in when it reaches the value.
+type ColorStop struct {
+	Value float64 `yaml:"value"`
+	Color string  `yaml:"color"`
+}
+
+type rgb [3]float64
+
+// parseColor parses a color in the "#rrggbb" format.
+func parseColor(s string) (rgb, error) {
+	if len(s) != 7 || s[0] != '#' {
+		return rgb{}, fmt.Errorf("color %q must be in the #rrggbb format", s)
+	}
+
+	var c rgb
+	for i := range c {
+		v, err := strconv.ParseUint(s[1+i*2:3+i*2], 16, 8)
+		if err != nil {
+			return rgb{}, fmt.Errorf("color %q must be in the #rrggbb format", s)
+		}
+		c[i] = float64(v)
+	}
+	return c, nil
+}
+
+func (c rgb) String() string {
+	return fmt.Sprintf("#%02x%02x%02x", int(math.Round(c[0])), int(math.Round(c[1])), int(math.Round(c[2])))
+}
+
+// heatScale interpolates colors between a set of color stops.
+type heatScale struct {
+	values []float64
+	colors []rgb
+}
+
+// newHeatScale returns a heat scale for the stops.
+func newHeatScale(stops []ColorStop) (*heatScale, error) {
+	if len(stops) == 0 {
+		return nil, nil
+	}
+
+	sorted := append([]ColorStop{}, stops...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Value < sorted[j].Value })
+
+	h := &heatScale{
+		values: make([]float64, len(sorted)),
+		colors: make([]rgb, len(sorted)),
+	}
+	for i, stop := range sorted {
+		c, err := parseColor(stop.Color)
+		if err != nil {
+			return nil, err
+		}
+		h.values[i] = stop.Value
+		h.colors[i] = c
+	}
+	return h, nil
+}
+
+// color returns the color for the value, interpolated between the
+// surrounding stops. Values beyond the stops are clamped.
+func (h *heatScale) color(v float64) rgb {
+	last := len(h.values) - 1
+	switch {
+	case v <= h.values[0]:
+		return h.colors[0]
+	case v >= h.values[last]:
+		return h.colors[last]
+	}
+
+	i := sort.SearchFloat64s(h.values, v)
+	lo, hi := h.values[i-1], h.values[i]
+	t := (v - lo) / (hi - lo)
+
+	var c rgb
+	for j := range c {
+		c[j] = h.colors[i-1][j] + t*(h.colors[i][j]-h.colors[i-1][j])
+	}
+	return c
+}
@@ -0,0 +1,1224 @@
+package iotawatt
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// unsafeSelectChars are the characters that cannot be used in input
+// names, as the query select list has no quoting.
+const unsafeSelectChars = ",.[]"
+
+// jsIdentifier matches a plain javascript identifier.
+var jsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// unsafeDisplayChars are the characters that cannot be used in
+// display text as they would break the ui scripts or markup.
+const unsafeDisplayChars = "'\"\\<>&\n\r"
+
+// Config is the module configuration.
+type Config struct {
+	URL    string  `yaml:"url"`
+	Inputs []Input `yaml:"inputs"`
+
+	// FallbackURLs are tried in order when the request to URL fails.
+	FallbackURLs []string `yaml:"fallbackUrls"`
+
+	// Devices are additional devices whose inputs are
+	// merged with the inputs of the main device.
+	Devices []Device `yaml:"devices"`
+
+	Interval time.Duration `yaml:"interval"`
+
+	// Window is the time span of data queried and charted.
+	Window time.Duration `yaml:"window"`
+
+	// WindowPreset is a calendar window queried instead of the
+	// window, one of "lasthour", "today", "yesterday" or "thisweek".
+	WindowPreset string `yaml:"windowPreset"`
+
+	// OverviewWindow is the time span of a low resolution overview
+	// charted below the detail chart. Zero disables the overview.
+	OverviewWindow time.Duration `yaml:"overviewWindow"`
+
+	// Resolution is the query resolution, one of "auto", "low"
+	// or "high". Auto picks the resolution from the window.
+	Resolution string `yaml:"resolution"`
+
+	// AutoInterval reads the datalog interval of the main device at
+	// startup, raising the interval to it when it is shorter.
+	AutoInterval bool `yaml:"autoInterval"`
+
+	// DetectFirmware reads the firmware version of the main device
+	// at startup, reporting it in the status and adjusting the query
+	// select format to it.
+	DetectFirmware bool `yaml:"detectFirmware"`
+
+	// ChartInterval is the minimum time between chart updates,
+	// allowing the current value to be polled more often than the
+	// chart is redrawn. Zero updates the chart on every poll.
+	ChartInterval time.Duration `yaml:"chartInterval"`
+
+	// StatsInterval is the minimum time between stats updates. Zero
+	// updates the stats on every poll.
+	StatsInterval time.Duration `yaml:"statsInterval"`
+
+	// BearerToken is sent as a bearer token in the authorization
+	// header of each request, e.g. for proxied devices. It cannot
+	// be used with basic auth credentials in the urls.
+	BearerToken string `yaml:"bearerToken"`
+
+	// RequestIDHeader is the header each poll request carries a
+	// unique id in, logged with poll errors. Empty disables it.
+	RequestIDHeader string `yaml:"requestIdHeader"`
+
+	// RequireConnection fails startup when a device cannot be reached.
+	RequireConnection bool `yaml:"requireConnection"`
+
+	// StartupGrace is how long the connection is retried at
+	// startup before failing, e.g. while the network comes up.
+	StartupGrace time.Duration `yaml:"startupGrace"`
+
+	// Format is the format the data is queried in, either "json" or "csv".
+	Format string `yaml:"format"`
+
+	// Missing is how the device reports missing data,
+	// one of "null", "skip" or "zero".
+	Missing string `yaml:"missing"`
+
+	// Limit is the maximum number of rows the device returns.
+	// Zero uses the device default.
+	Limit int `yaml:"limit"`
+
+	// MaxResponseSize is the maximum size in bytes of a device
+	// response, bounding the memory used to decode it.
+	MaxResponseSize int64 `yaml:"maxResponseSize"`
+
+	// QueryParams are extra query parameters sent to the device,
+	// overriding the defaults. The format and select parameters
+	// are reserved and ignored.
+	QueryParams map[string]string `yaml:"queryParams"`
+
+	// AlignWindow rounds the begin and end of the query window down
+	// to the boundary of the group set in the query params, so the
+	// first and last groups are whole.
+	AlignWindow bool `yaml:"alignWindow"`
+
+	// Timezone is the IANA time zone the window is aligned and the
+	// schedule evaluated in.
+	// By default the local time zone is used.
+	Timezone string `yaml:"timezone"`
+
+	// TimeColumn is the position of the time column in the
+	// query, either "first" or "last".
+	TimeColumn string `yaml:"timeColumn"`
+
+	// ResponseKey is the object key holding the data when the
+	// response is wrapped in an object rather than a bare array.
+	ResponseKey string `yaml:"responseKey"`
+
+	// Strict treats a response with more columns than requested
+	// as an error rather than a warning.
+	Strict bool `yaml:"strict"`
+
+	// StrictDecode treats a response object with keys other than
+	// the response key, or data after the response, as an error
+	// rather than ignoring it.
+	StrictDecode bool `yaml:"strictDecode"`
+
+	// MaxRetryAfter caps how long polling is delayed when the device
+	// throttles requests with a Retry-After header.
+	MaxRetryAfter time.Duration `yaml:"maxRetryAfter"`
+
+	// DegradedAfter is the number of consecutive failed polls after
+	// which the connection is degraded.
+	DegradedAfter int `yaml:"degradedAfter"`
+
+	// DisconnectedAfter is the number of consecutive failed polls
+	// after which the connection is disconnected.
+	DisconnectedAfter int `yaml:"disconnectedAfter"`
+
+	// MaxBackoff caps the poll interval, doubled on each failed poll
+	// while disconnected. Zero keeps polling at the interval.
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+
+	// MaxRequestsPerMinute caps the number of requests made to the
+	// devices, including retries. Zero disables the cap.
+	MaxRequestsPerMinute int `yaml:"maxRequestsPerMinute"`
+
+	// MaxConcurrentRequests is the maximum number of requests to the
+	// devices in flight at once.
+	MaxConcurrentRequests int `yaml:"maxConcurrentRequests"`
+
+	// MaxIdleConns is the maximum number of idle connections kept to the device.
+	MaxIdleConns int `yaml:"maxIdleConns"`
+
+	// ConnectTimeout is how long connecting to a device may take,
+	// allowing a quick fail over to the fallback urls. Zero only
+	// limits it by the interval.
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
+
+	// ResponseTimeout is how long a device may take to respond
+	// once connected. Zero only limits it by the interval.
+	ResponseTimeout time.Duration `yaml:"responseTimeout"`
+
+	// RequestTimeout is how long a whole request may take. It is
+	// doubled once TimeoutEscalationAfter consecutive requests timed
+	// out, and after each further one, up to MaxRequestTimeout, and
+	// halved back on each successful request. Zero only limits it by
+	// the interval.
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+
+	// MaxRequestTimeout caps the escalated request timeout. Zero
+	// caps it at the interval.
+	MaxRequestTimeout time.Duration `yaml:"maxRequestTimeout"`
+
+	// TimeoutEscalationAfter is the number of consecutive timed out
+	// requests before the request timeout is escalated.
+	TimeoutEscalationAfter int `yaml:"timeoutEscalationAfter"`
+
+	// IdleConnTimeout is how long an idle connection is kept open.
+	IdleConnTimeout time.Duration `yaml:"idleConnTimeout"`
+
+	// DisableKeepAlives opens a new connection for every request.
+	DisableKeepAlives bool `yaml:"disableKeepAlives"`
+
+	// DisableHTTP2 forces http/1.1, for devices or proxies that
+	// misbehave with http/2.
+	DisableHTTP2 bool `yaml:"disableHTTP2"`
+
+	// Scale is the per-input factor the readings are multiplied by.
+	Scale map[string]float64 `yaml:"scale"`
+
+	// Duplicates is how rows with the same timestamp are merged,
+	// either "average" or "last".
+	Duplicates string `yaml:"duplicates"`
+
+	// MinCadence is the shortest time between rows, rows closer
+	// together are averaged into buckets of this size. Zero keeps
+	// the rows as returned.
+	MinCadence time.Duration `yaml:"minCadence"`
+
+	// Schedule limits the charted rows and stats to the scheduled
+	// hours in the timezone. By default all rows are charted.
+	Schedule *Schedule `yaml:"schedule"`
+
+	// ClockSkew is how far outside the queried window a row
+	// timestamp may be before the row is skipped, guarding the chart
+	// against a device clock that is unset or wrong. Zero disables
+	// the check.
+	ClockSkew time.Duration `yaml:"clockSkew"`
+
+	// Alignment is how rows from multiple devices are aligned when
+	// they do not share the same timestamps, one of "pad",
+	// "truncate" or "interpolate".
+	Alignment string `yaml:"alignment"`
+
+	// CurrentRow is the row the current value is taken from,
+	// one of "latest", "earliest" or "max".
+	CurrentRow string `yaml:"currentRow"`
+
+	// Aggregate is how the inputs are aggregated into the
+	// current value, either "sum" or "mean".
+	Aggregate string `yaml:"aggregate"`
+
+	// NoiseFloor is the magnitude below which power readings are
+	// treated as zero, hiding the noise of idle CTs. Zero disables
+	// the floor.
+	NoiseFloor float64 `yaml:"noiseFloor"`
+
+	// ExcludeFromTotal are the inputs that are charted but
+	// not aggregated into the current value.
+	ExcludeFromTotal []string `yaml:"excludeFromTotal"`
+
+	// CurrentSmoothing is the exponential smoothing factor applied to
+	// the displayed current value, between 0 and 1. Zero disables smoothing.
+	CurrentSmoothing float64 `yaml:"currentSmoothing"`
+
+	// Combine sums groups of inputs into a single charted series,
+	// keyed by the name of the combined series.
+	Combine map[string][]string `yaml:"combine"`
+
+	// Derived are values computed from two inputs and shown below
+	// the current value, e.g. the power factor from watts and VA.
+	Derived []Derived `yaml:"derived"`
+
+	// ShowInputs displays the latest value of each input in its
+	// own element below the current value.
+	ShowInputs bool `yaml:"showInputs"`
+
+	// HideCombined hides the inputs that are part of a combined series
+	// from the chart.
+	HideCombined bool `yaml:"hideCombined"`
+
+	// Legend shows the chart legend. Tapping an input in the legend
+	// hides or shows it.
+	Legend bool `yaml:"legend"`
+
+	// VisibilityFile is the file, relative to the module path, the
+	// input visibility is persisted in. Empty keeps it in memory.
+	VisibilityFile string `yaml:"visibilityFile"`
+
+	// ExcludeHidden excludes hidden inputs from the current total.
+	ExcludeHidden bool `yaml:"excludeHidden"`
+
+	// HeatColors are the colors the current value is displayed in
+	// based on its value, interpolated between the stops.
+	HeatColors []ColorStop `yaml:"heatColors"`
+
+	// ShowTrend displays whether the total is rising or falling.
+	ShowTrend bool `yaml:"showTrend"`
+
+	// TrendSamples is the number of latest samples the trend is
+	// taken over.
+	TrendSamples int `yaml:"trendSamples"`
+
+	// TrendThreshold is the change in watts per minute above which
+	// the total is rising or falling.
+	TrendThreshold float64 `yaml:"trendThreshold"`
+
+	// ShowStats displays the minimum, average and maximum total
+	// over the window.
+	ShowStats bool `yaml:"showStats"`
+
+	// WarmupPolls is the number of polls made before the stats
+	// are displayed.
+	WarmupPolls int `yaml:"warmupPolls"`
+
+	// WarmupText is displayed instead of the stats during warmup.
+	WarmupText string `yaml:"warmupText"`
+
+	// SessionEnergy displays the energy used since the counter was last reset.
+	SessionEnergy bool `yaml:"sessionEnergy"`
+
+	// SessionFile is the file, relative to the module path, the
+	// session energy counter is persisted in.
+	SessionFile string `yaml:"sessionFile"`
+
+	// SessionSaveInterval is the shortest time between session saves.
+	// The session is always saved when reset and on close.
+	SessionSaveInterval time.Duration `yaml:"sessionSaveInterval"`
+
+	// SnapshotFile is the file, relative to the module path, the
+	// last polled data is persisted in to populate the module on
+	// restart. Empty disables the snapshot.
+	SnapshotFile string `yaml:"snapshotFile"`
+
+	// SnapshotInterval is the shortest time between snapshot saves.
+	SnapshotInterval time.Duration `yaml:"snapshotInterval"`
+
+	// ReferenceLines are static lines drawn on the chart.
+	ReferenceLines []ReferenceLine `yaml:"referenceLines"`
+
+	// ChartType is the type of chart, one of "spline", "line",
+	// "area" or "bar".
+	ChartType string `yaml:"chartType"`
+
+	// LineWidth is the width of the chart lines in pixels.
+	LineWidth float64 `yaml:"lineWidth"`
+
+	// Markers shows a marker at each charted point.
+	Markers bool `yaml:"markers"`
+
+	// MarkerRadius is the radius of the point markers in pixels.
+	MarkerRadius float64 `yaml:"markerRadius"`
+
+	// Stacked charts the series as stacked areas rather than lines.
+	Stacked bool `yaml:"stacked"`
+
+	// EventsEndpoint is the endpoint of the main device returning
+	// the events drawn on the chart. Empty disables events.
+	EventsEndpoint string `yaml:"eventsEndpoint"`
+
+	// EventColor is the color of the event markers, e.g. "#ff0000".
+	EventColor string `yaml:"eventColor"`
+
+	// TimeFormat is the date format of the chart time axis labels,
+	// e.g. "%H:%M". The time axis is hidden when empty.
+	TimeFormat string `yaml:"timeFormat"`
+
+	// TimeTickInterval is the interval between the chart time axis ticks.
+	TimeTickInterval time.Duration `yaml:"timeTickInterval"`
+
+	// CurrentSelector is the css selector of the current value
+	// element within the module, for edited module html.
+	CurrentSelector string `yaml:"currentSelector"`
+
+	// ChartVariable is the javascript variable holding the chart.
+	ChartVariable string `yaml:"chartVariable"`
+
+	// SeriesVariable is the javascript variable the charted series
+	// are set in.
+	SeriesVariable string `yaml:"seriesVariable"`
+
+	// ReloadFunction is the javascript function recreating the chart.
+	ReloadFunction string `yaml:"reloadFunction"`
+
+	// DisplayMode is how the data is displayed, either "chart" for
+	// the time series or "gauge" for a gauge of the current total.
+	DisplayMode string `yaml:"displayMode"`
+
+	// GaugeMin is the lowest value of the gauge in watts.
+	GaugeMin float64 `yaml:"gaugeMin"`
+
+	// GaugeMax is the highest value of the gauge in watts.
+	GaugeMax float64 `yaml:"gaugeMax"`
+
+	// NumberOnly displays only the current value without the chart.
+	NumberOnly bool `yaml:"numberOnly"`
+
+	// Rounding is how the current value is rounded for display,
+	// one of "truncate", "half-up" or "half-even".
+	Rounding string `yaml:"rounding"`
+
+	// DecimalSeparator is the separator used in the current value.
+	DecimalSeparator string `yaml:"decimalSeparator"`
+
+	// WattSuffix is the text displayed after a current value in watts.
+	WattSuffix string `yaml:"wattSuffix"`
+
+	// KilowattSuffix is the text displayed after a current value in kilowatts.
+	KilowattSuffix string `yaml:"kilowattSuffix"`
+
+	// DisplayUnit is the unit the current value is displayed in,
+	// one of "power", "btu" for BTU/h or "cost" for the cost per
+	// hour at the tariff. The chart is always in watts.
+	DisplayUnit string `yaml:"displayUnit"`
+
+	// Tariff is the cost of a kWh, used with the cost display unit.
+	Tariff float64 `yaml:"tariff"`
+
+	// CurrencySymbol is displayed before the cost per hour.
+	CurrencySymbol string `yaml:"currencySymbol"`
+
+	// IncludeTimes also pushes the charted timestamps to the
+	// front-end as a separate array.
+	IncludeTimes bool `yaml:"includeTimes"`
+
+	// GapFill is how gaps of missing charted points are filled,
+	// one of "none", "linear" or "zero".
+	GapFill string `yaml:"gapFill"`
+
+	// MaxGap is the longest gap that is filled.
+	MaxGap time.Duration `yaml:"maxGap"`
+
+	// TrimNulls removes the missing points at either end of each
+	// charted series.
+	TrimNulls bool `yaml:"trimNulls"`
+
+	// Decimation is how the charted points are reduced when there
+	// are more than MaxPoints, either "none" or "minmax".
+	Decimation string `yaml:"decimation"`
+
+	// DeltaUpdates appends the points new since the last poll to the
+	// chart rather than replacing all of its series.
+	DeltaUpdates bool `yaml:"deltaUpdates"`
+
+	// MaxPoints is the number of charted points per series above
+	// which the points are decimated.
+	MaxPoints int `yaml:"maxPoints"`
+
+	// TimestampMillis charts timestamps in milliseconds rather than seconds.
+	TimestampMillis bool `yaml:"timestampMillis"`
+
+	// ErrorTooltip sets the last poll error as the title of the
+	// module, shown as a tooltip while the data is stale.
+	ErrorTooltip bool `yaml:"errorTooltip"`
+
+	// MaxDataAge is the age of the last successfully fetched data
+	// after which the chart is cleared. Zero disables clearing.
+	MaxDataAge time.Duration `yaml:"maxDataAge"`
+
+	// NoDataDisplay is shown as the current value when a poll
+	// fails or returns no data. When empty, the last value is
+	// kept and marked as stale.
+	NoDataDisplay string `yaml:"noDataDisplay"`
+
+	// CaptureFile is the file, relative to the module path, the raw
+	// device responses are captured to for debugging. Empty
+	// disables capturing.
+	CaptureFile string `yaml:"captureFile"`
+
+	// CaptureMaxSize is the size in bytes after which the capture
+	// file is rotated.
+	CaptureMaxSize int64 `yaml:"captureMaxSize"`
+
+	// CaptureCompress gzips the rotated capture file.
+	CaptureCompress bool `yaml:"captureCompress"`
+
+	// BatchEvals sends the ui updates of each poll in a single
+	// script, reducing round trips on slow ui bridges.
+	BatchEvals bool `yaml:"batchEvals"`
+
+	// ErrorLogWindow is the window repeated identical poll errors
+	// are logged at most once in. Zero logs every error.
+	ErrorLogWindow time.Duration `yaml:"errorLogWindow"`
+
+	// UIRetries is the number of times rendering the module in the
+	// ui is retried at startup, for uis that are slow to be ready.
+	UIRetries int `yaml:"uiRetries"`
+
+	// UIRetryDelay is the delay between the ui render retries.
+	UIRetryDelay time.Duration `yaml:"uiRetryDelay"`
+
+	// CheckUI verifies the ui bridge evaluates scripts at startup.
+	CheckUI bool `yaml:"checkUI"`
+
+	// HeartbeatInterval is how often the ui is checked to still be alive.
+	// Zero disables the check.
+	HeartbeatInterval time.Duration `yaml:"heartbeatInterval"`
+
+	// HeartbeatFailures is the number of consecutive failed checks
+	// after which the ui is considered lost.
+	HeartbeatFailures int `yaml:"heartbeatFailures"`
+
+	// PauseOnUILoss pauses polling while the ui is lost.
+	PauseOnUILoss bool `yaml:"pauseOnUILoss"`
+}
+
+// Device is an additional IoTaWatt device to monitor.
+type Device struct {
+	URL          string   `yaml:"url"`
+	FallbackURLs []string `yaml:"fallbackUrls"`
+	Inputs       []Input  `yaml:"inputs"`
+}
+
+// Input is an input or output to monitor. It is configured either
+// as just its name or as a mapping of its settings.
+type Input struct {
+	Name string `yaml:"name"`
+
+	// Label is the name shown in the chart legend.
+	// By default the input name is used.
+	Label string `yaml:"label"`
+
+	// Unit is the unit the input is queried in, e.g. "volts".
+	// By default the device unit of the input is used.
+	Unit string `yaml:"unit"`
+
+	// Axis is the chart axis the input is charted on, either "left"
+	// or "right". Inputs on the right axis are not part of the total.
+	Axis string `yaml:"axis"`
+
+	// Kind is the kind of value of the input, one of "power", "va"
+	// for apparent power, "var" for reactive power or "pf" for a
+	// power factor. By default it is taken from the unit.
+	Kind string `yaml:"kind"`
+
+	// Alert is the value above which the input is in alert.
+	// Zero disables the alert.
+	Alert float64 `yaml:"alert"`
+
+	// AlertHysteresis is how far below the alert value the input
+	// must fall to leave the alert.
+	AlertHysteresis float64 `yaml:"alertHysteresis"`
+
+	// NoiseFloor overrides the noise floor of the input.
+	NoiseFloor *float64 `yaml:"noiseFloor"`
+}
+
+// Schedule is the hours of the week data is charted for.
+type Schedule struct {
+	// Days are the days of the week, e.g. "mon". By default every
+	// day is scheduled.
+	Days []string `yaml:"days"`
+
+	// Start is the time of day the schedule starts, e.g. "07:00".
+	Start string `yaml:"start"`
+
+	// End is the time of day the schedule ends, e.g. "19:00". An
+	// end before the start spans midnight.
+	End string `yaml:"end"`
+}
+
+// Derived is a value computed from two inputs.
+type Derived struct {
+	// Name is the name shown with the value.
+	Name string `yaml:"name"`
+
+	// Formula is how the value is computed, currently only "ratio"
+	// of the first input to the second.
+	Formula string `yaml:"formula"`
+
+	// Inputs are the numerator and denominator inputs.
+	Inputs []string `yaml:"inputs"`
+}
+
+// UnmarshalYAML unmarshals an input from either its name or its settings.
+func (i *Input) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*i = Input{Name: name}
+		return nil
+	}
+
+	type plain Input
+	return unmarshal((*plain)(i))
+}
+
+// selectName returns the name of the input in the query select.
+func (i Input) selectName() string {
+	if i.Unit == "" {
+		return i.Name
+	}
+	return i.Name + "." + i.Unit
+}
+
+// unitSymbols are the symbols of the input units shown in the legend.
+var unitSymbols = map[string]string{
+	"volts": "V",
+	"hz":    "Hz",
+	"watts": "W",
+	"amps":  "A",
+	"va":    "VA",
+	"var":   "VAR",
+	"varh":  "VARh",
+	"wh":    "Wh",
+}
+
+// legendName returns the name of the input in the chart legend,
+// followed by the symbol of its unit when set.
+func (i Input) legendName() string {
+	name := i.Label
+	if name == "" {
+		name = i.Name
+	}
+	if sym := unitSymbols[strings.ToLower(i.Unit)]; sym != "" {
+		name += " (" + sym + ")"
+	}
+	return name
+}
+
+// kind returns the kind of value of the input. Inputs in other
+// units than watts, e.g. volts or amps, are of kind "other".
+func (i Input) kind() string {
+	if i.Kind != "" {
+		return i.Kind
+	}
+	switch strings.ToLower(i.Unit) {
+	case "", "watts":
+		return "power"
+	case "pf":
+		return "pf"
+	case "va":
+		return "va"
+	case "var":
+		return "var"
+	}
+	return "other"
+}
+
+// axis returns the chart axis index of the input. Power factors are
+// charted on the right axis by default, as their scale differs.
+func (i Input) axis() int {
+	if i.Axis == "right" || (i.Axis == "" && i.kind() == "pf") {
+		return 1
+	}
+	return 0
+}
+
+// chart reports whether the time series chart is displayed.
+func (c *Config) chart() bool {
+	return !c.NumberOnly && c.DisplayMode == "chart"
+}
+
+// allInputs returns the inputs of the main device followed
+// by the inputs of each additional device.
+func (c *Config) allInputs() []Input {
+	inputs := append([]Input{}, c.Inputs...)
+	for _, d := range c.Devices {
+		inputs = append(inputs, d.Inputs...)
+	}
+	return inputs
+}
+
+// inputNames returns the names of the inputs of all devices.
+func (c *Config) inputNames() []string {
+	inputs := c.allInputs()
+	names := make([]string, len(inputs))
+	for i, in := range inputs {
+		names[i] = in.Name
+	}
+	return names
+}
+
+// span returns the longest time span of data queried.
+func (c *Config) span() time.Duration {
+	if p, ok := windowPresets[c.WindowPreset]; ok {
+		return p.span
+	}
+	return c.Window
+}
+
+// location returns the time zone the window is aligned in.
+func (c *Config) location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// NewConfig creates a default configuration for the module.
+func NewConfig() *Config {
+	return &Config{
+		Interval:               time.Minute,
+		Window:                 time.Hour,
+		Resolution:             "auto",
+		Format:                 "json",
+		RequestIDHeader:        "X-Request-ID",
+		Missing:                "skip",
+		ResponseKey:            "series",
+		TimeColumn:             "first",
+		GapFill:                "none",
+		MaxGap:                 5 * time.Minute,
+		Decimation:             "none",
+		ChartType:              "spline",
+		LineWidth:              1,
+		MarkerRadius:           2,
+		DisplayMode:            "chart",
+		CurrentSelector:        ".current",
+		ChartVariable:          "iotaWattChart",
+		SeriesVariable:         "iotaWattSeries",
+		ReloadFunction:         "reloadChart",
+		GaugeMax:               5000,
+		EventColor:             "#ff6666",
+		MaxRetryAfter:          10 * time.Minute,
+		TimeoutEscalationAfter: 2,
+		MaxConcurrentRequests:  4,
+		DegradedAfter:          1,
+		DisconnectedAfter:      3,
+		MaxResponseSize:        8 << 20,
+		Duplicates:             "average",
+		ClockSkew:              24 * time.Hour,
+		Alignment:              "pad",
+		CurrentRow:             "latest",
+		Aggregate:              "sum",
+		Rounding:               "truncate",
+		DecimalSeparator:       ".",
+		WattSuffix:             "W",
+		KilowattSuffix:         "kW",
+		DisplayUnit:            "power",
+		CurrencySymbol:         "$",
+		SessionFile:            "session.json",
+		SessionSaveInterval:    time.Minute,
+		VisibilityFile:         "visibility.json",
+		SnapshotInterval:       5 * time.Minute,
+		WarmupText:             "collecting…",
+		TrendSamples:           3,
+		TrendThreshold:         10,
+		CaptureMaxSize:         1 << 20,
+		HeartbeatFailures:      3,
+		UIRetryDelay:           time.Second,
+	}
+}
+
+// Validate checks the configuration, returning an error
+// describing every invalid setting.
+func (c *Config) Validate() error {
+	var errs []string
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if c.URL == "" {
+		addErr("url is required")
+	}
+	for _, rawURL := range append([]string{c.URL}, c.FallbackURLs...) {
+		if rawURL == "" {
+			continue
+		}
+		if err := validateURL(rawURL); err != nil {
+			addErr("invalid url %q: %v", redactURL(rawURL), err)
+		}
+	}
+	if len(c.Inputs) == 0 {
+		addErr("at least one input is required")
+	}
+	for i, d := range c.Devices {
+		if d.URL == "" {
+			addErr("device %d url is required", i+1)
+		}
+		for _, rawURL := range append([]string{d.URL}, d.FallbackURLs...) {
+			if rawURL == "" {
+				continue
+			}
+			if err := validateURL(rawURL); err != nil {
+				addErr("invalid device %d url %q: %v", i+1, redactURL(rawURL), err)
+			}
+		}
+		if len(d.Inputs) == 0 {
+			addErr("device %d requires at least one input", i+1)
+		}
+	}
+	if c.BearerToken != "" {
+		urls := append([]string{c.URL}, c.FallbackURLs...)
+		for _, d := range c.Devices {
+			urls = append(append(urls, d.URL), d.FallbackURLs...)
+		}
+		for _, rawURL := range urls {
+			if u, err := url.Parse(rawURL); err == nil && u.User != nil {
+				addErr("bearerToken cannot be used with basic auth credentials in the urls")
+				break
+			}
+		}
+	}
+	seen := map[string]bool{}
+	for _, in := range c.allInputs() {
+		if strings.TrimSpace(in.Name) == "" {
+			addErr("input names cannot be empty")
+		}
+		if strings.HasPrefix(strings.ToLower(in.Name), "time.") {
+			addErr("input %q is a time field, the time is always queried", in.Name)
+		}
+		if strings.ContainsAny(in.Name, unsafeSelectChars) {
+			addErr("input %q cannot contain any of %q", in.Name, unsafeSelectChars)
+		}
+		if seen[in.Name] {
+			addErr("input %q is configured more than once", in.Name)
+		}
+		seen[in.Name] = true
+		switch strings.ToLower(in.Unit) {
+		case "", "volts", "hz", "watts", "amps", "va", "var", "varh", "pf", "wh":
+		default:
+			addErr("input %q has unsupported unit %q", in.Name, in.Unit)
+		}
+		switch in.Axis {
+		case "", "left", "right":
+		default:
+			addErr("input %q has unsupported axis %q", in.Name, in.Axis)
+		}
+		switch in.Kind {
+		case "", "power", "va", "var", "pf":
+		default:
+			addErr("input %q has unsupported kind %q", in.Name, in.Kind)
+		}
+		if in.NoiseFloor != nil && (math.IsNaN(*in.NoiseFloor) || *in.NoiseFloor < 0) {
+			addErr("input %q noiseFloor cannot be negative", in.Name)
+		}
+		if in.Alert != 0 {
+			if math.IsNaN(in.Alert) || math.IsInf(in.Alert, 0) {
+				addErr("input %q alert must be finite", in.Name)
+			}
+			if math.IsNaN(in.AlertHysteresis) || in.AlertHysteresis < 0 {
+				addErr("input %q alertHysteresis cannot be negative", in.Name)
+			}
+			if !alertClassName.MatchString(in.Name) {
+				addErr("input %q with an alert can only contain letters, digits, '-' and '_'", in.Name)
+			}
+		}
+		if c.ShowInputs && !alertClassName.MatchString(in.Name) {
+			addErr("input %q shown with showInputs can only contain letters, digits, '-' and '_'", in.Name)
+		}
+	}
+	if c.Interval <= 0 {
+		addErr("interval must be positive")
+	}
+
+	switch c.Format {
+	case "json", "csv":
+	default:
+		addErr("unsupported format %q", c.Format)
+	}
+	switch c.Missing {
+	case "null", "skip", "zero":
+	default:
+		addErr("unsupported missing %q", c.Missing)
+	}
+	if c.Limit < 0 {
+		addErr("limit must be positive")
+	}
+	if c.ResponseKey == "" {
+		addErr("responseKey is required")
+	}
+	if c.MaxRetryAfter < 0 {
+		addErr("maxRetryAfter cannot be negative")
+	}
+	if c.MaxRequestsPerMinute < 0 {
+		addErr("maxRequestsPerMinute cannot be negative")
+	}
+	if c.MaxConcurrentRequests < 1 {
+		addErr("maxConcurrentRequests must be positive")
+	}
+	if c.MaxIdleConns < 0 {
+		addErr("maxIdleConns cannot be negative")
+	}
+	if c.IdleConnTimeout < 0 {
+		addErr("idleConnTimeout cannot be negative")
+	}
+
+	names := c.inputNames()
+	for in := range c.Scale {
+		if !contains(names, in) {
+			addErr("scaled input %q is not a configured input", in)
+		}
+	}
+	switch c.TimeColumn {
+	case "first", "last":
+	default:
+		addErr("unsupported timeColumn %q", c.TimeColumn)
+	}
+	switch c.GapFill {
+	case "none", "linear", "zero":
+	default:
+		addErr("unsupported gapFill %q", c.GapFill)
+	}
+	if c.MaxGap < 0 {
+		addErr("maxGap cannot be negative")
+	}
+	switch c.Decimation {
+	case "none":
+	case "minmax":
+		if c.MaxPoints < 2 {
+			addErr("maxPoints must be at least 2 with minmax decimation")
+		}
+		if c.IncludeTimes {
+			addErr("decimation cannot be used with includeTimes")
+		}
+	default:
+		addErr("unsupported decimation %q", c.Decimation)
+	}
+	switch c.Duplicates {
+	case "average", "last":
+	default:
+		addErr("unsupported duplicates %q", c.Duplicates)
+	}
+	switch c.Alignment {
+	case "pad", "truncate", "interpolate":
+	default:
+		addErr("unsupported alignment %q", c.Alignment)
+	}
+	switch c.CurrentRow {
+	case "latest", "earliest", "max":
+	default:
+		addErr("unsupported currentRow %q", c.CurrentRow)
+	}
+	switch c.Aggregate {
+	case "sum", "mean":
+	default:
+		addErr("unsupported aggregate %q", c.Aggregate)
+	}
+	if _, err := newTotals(c.allInputs(), c.ExcludeFromTotal); err != nil {
+		addErr("%v", err)
+	}
+	if c.CurrentSmoothing < 0 || c.CurrentSmoothing > 1 {
+		addErr("currentSmoothing must be between 0 and 1")
+	}
+	if _, _, err := newCombined(c.allInputs(), c.Combine); err != nil {
+		addErr("%v", err)
+	}
+	if _, err := newDerived(c.allInputs(), c.Derived); err != nil {
+		addErr("%v", err)
+	}
+	for _, d := range c.Derived {
+		if d.Name == "" || strings.ContainsAny(d.Name, unsafeDisplayChars) {
+			addErr("derived names cannot be empty or contain any of %q", unsafeDisplayChars)
+		}
+	}
+
+	switch c.DisplayUnit {
+	case "power", "btu":
+	case "cost":
+		if math.IsNaN(c.Tariff) || math.IsInf(c.Tariff, 0) || c.Tariff <= 0 {
+			addErr("tariff must be positive with the cost displayUnit")
+		}
+	default:
+		addErr("unsupported displayUnit %q", c.DisplayUnit)
+	}
+	switch c.Rounding {
+	case "truncate", "half-up", "half-even":
+	default:
+		addErr("unsupported rounding %q", c.Rounding)
+	}
+	for name, text := range map[string]string{
+		"decimalSeparator": c.DecimalSeparator,
+		"wattSuffix":       c.WattSuffix,
+		"kilowattSuffix":   c.KilowattSuffix,
+		"noDataDisplay":    c.NoDataDisplay,
+		"warmupText":       c.WarmupText,
+		"currencySymbol":   c.CurrencySymbol,
+	} {
+		if strings.ContainsAny(text, unsafeDisplayChars) {
+			addErr("%s cannot contain any of %q", name, unsafeDisplayChars)
+		}
+	}
+	if c.CurrentSelector == "" || strings.ContainsAny(c.CurrentSelector, "'\\\n\r") {
+		addErr("currentSelector cannot be empty or contain quotes, backslashes or newlines")
+	}
+	for name, ident := range map[string]string{
+		"chartVariable":  c.ChartVariable,
+		"seriesVariable": c.SeriesVariable,
+		"reloadFunction": c.ReloadFunction,
+	} {
+		if !jsIdentifier.MatchString(ident) {
+			addErr("%s %q is not a javascript identifier", name, ident)
+		}
+	}
+	if _, err := newHeatScale(c.HeatColors); err != nil {
+		addErr("invalid heatColors: %v", err)
+	}
+	for _, line := range c.ReferenceLines {
+		if math.IsNaN(line.Value) || math.IsInf(line.Value, 0) {
+			addErr("reference line %q value must be finite", line.Label)
+		}
+		if line.Color == "" {
+			continue
+		}
+		if _, err := parseColor(line.Color); err != nil {
+			addErr("reference line %q: %v", line.Label, err)
+		}
+	}
+	switch c.DisplayMode {
+	case "chart":
+	case "gauge":
+		if c.NumberOnly {
+			addErr("gauge displayMode cannot be used with numberOnly")
+		}
+		if math.IsNaN(c.GaugeMin) || math.IsInf(c.GaugeMin, 0) || math.IsNaN(c.GaugeMax) || math.IsInf(c.GaugeMax, 0) {
+			addErr("gaugeMin and gaugeMax must be finite")
+		} else if c.GaugeMax <= c.GaugeMin {
+			addErr("gaugeMax must be greater than gaugeMin")
+		}
+	default:
+		addErr("unsupported displayMode %q", c.DisplayMode)
+	}
+	if math.IsNaN(c.LineWidth) || c.LineWidth <= 0 || c.LineWidth > 10 {
+		addErr("lineWidth must be greater than 0 and at most 10")
+	}
+	if math.IsNaN(c.MarkerRadius) || c.MarkerRadius <= 0 || c.MarkerRadius > 10 {
+		addErr("markerRadius must be greater than 0 and at most 10")
+	}
+	if _, ok := chartTypes[c.ChartType]; !ok {
+		addErr("unsupported chartType %q", c.ChartType)
+	}
+	if c.EventsEndpoint != "" {
+		if _, err := parseColor(c.EventColor); err != nil {
+			addErr("invalid eventColor: %v", err)
+		}
+	}
+	if err := validateTimeFormat(c.TimeFormat); err != nil {
+		addErr("invalid timeFormat: %v", err)
+	}
+	if c.TimeTickInterval < 0 {
+		addErr("timeTickInterval cannot be negative")
+	}
+	if c.TrendSamples < 2 {
+		addErr("trendSamples must be at least 2")
+	}
+	if math.IsNaN(c.NoiseFloor) || c.NoiseFloor < 0 {
+		addErr("noiseFloor cannot be negative")
+	}
+	if math.IsNaN(c.TrendThreshold) || c.TrendThreshold < 0 {
+		addErr("trendThreshold cannot be negative")
+	}
+	if c.WarmupPolls < 0 {
+		addErr("warmupPolls cannot be negative")
+	}
+	if c.SnapshotInterval < 0 {
+		addErr("snapshotInterval cannot be negative")
+	}
+	if c.SessionSaveInterval < 0 {
+		addErr("sessionSaveInterval cannot be negative")
+	}
+	if c.SessionEnergy && c.SessionFile == "" {
+		addErr("sessionFile is required with sessionEnergy")
+	}
+	if c.MaxResponseSize <= 0 {
+		addErr("maxResponseSize must be positive")
+	}
+	if c.CaptureFile != "" && c.CaptureMaxSize <= 0 {
+		addErr("captureMaxSize must be positive")
+	}
+	if c.Window < time.Second {
+		addErr("window must be at least 1s")
+	}
+	if c.WindowPreset != "" {
+		if _, ok := windowPresets[c.WindowPreset]; !ok {
+			addErr("unsupported windowPreset %q", c.WindowPreset)
+		}
+		if c.AlignWindow {
+			addErr("alignWindow cannot be used with windowPreset")
+		}
+	}
+	if c.OverviewWindow != 0 && c.OverviewWindow < c.span() {
+		addErr("overviewWindow must be longer than window")
+	}
+	if c.DeltaUpdates && c.Decimation != "none" {
+		addErr("deltaUpdates cannot be used with decimation")
+	}
+	switch c.Resolution {
+	case "auto", "low", "high":
+	default:
+		addErr("unsupported resolution %q", c.Resolution)
+	}
+	if c.ConnectTimeout < 0 || c.ResponseTimeout < 0 {
+		addErr("connectTimeout and responseTimeout cannot be negative")
+	}
+	if c.RequestTimeout < 0 || c.MaxRequestTimeout < 0 {
+		addErr("requestTimeout and maxRequestTimeout cannot be negative")
+	}
+	if c.MaxRequestTimeout != 0 && c.MaxRequestTimeout < c.RequestTimeout {
+		addErr("maxRequestTimeout cannot be less than requestTimeout")
+	}
+	if c.TimeoutEscalationAfter < 1 {
+		addErr("timeoutEscalationAfter must be positive")
+	}
+	if c.MinCadence < 0 {
+		addErr("minCadence cannot be negative")
+	}
+	if c.AlignWindow {
+		if _, _, err := parseGroup(c.QueryParams["group"]); err != nil {
+			addErr("alignWindow requires a fixed group in queryParams, e.g. 1m: %v", err)
+		}
+	}
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			addErr("unsupported timezone %q", c.Timezone)
+		}
+	}
+	if _, err := newSchedule(c.Schedule, time.UTC); err != nil {
+		addErr("%v", err)
+	}
+	if c.ClockSkew < 0 {
+		addErr("clockSkew cannot be negative")
+	}
+	if c.UIRetries < 0 {
+		addErr("uiRetries cannot be negative")
+	}
+	if c.UIRetries > 0 && c.UIRetryDelay <= 0 {
+		addErr("uiRetryDelay must be positive")
+	}
+	if c.ErrorLogWindow < 0 {
+		addErr("errorLogWindow cannot be negative")
+	}
+	if c.ChartInterval < 0 {
+		addErr("chartInterval cannot be negative")
+	}
+	if c.StatsInterval < 0 {
+		addErr("statsInterval cannot be negative")
+	}
+	if c.StartupGrace < 0 {
+		addErr("startupGrace cannot be negative")
+	}
+	if c.MaxDataAge < 0 {
+		addErr("maxDataAge cannot be negative")
+	}
+	if c.DegradedAfter < 1 || c.DisconnectedAfter < c.DegradedAfter {
+		addErr("degradedAfter must be positive and disconnectedAfter cannot be less than it")
+	}
+	if c.MaxBackoff < 0 {
+		addErr("maxBackoff cannot be negative")
+	}
+
+	if c.HeartbeatInterval < 0 {
+		addErr("heartbeatInterval cannot be negative")
+	}
+	if c.HeartbeatFailures < 1 {
+		addErr("heartbeatFailures must be at least 1")
+	}
+
+	if len(errs) > 0 {
+		return errors.New("iotawatt: invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return parseURLError(err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("scheme must be http or https")
+	}
+	if u.Host == "" {
+		return errors.New("host is required")
+	}
+	return nil
+}
+
+// redactURL returns the url with any user info redacted. Urls that
+// cannot be parsed are not returned at all, as they may hold
+// credentials that cannot be located.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<invalid>"
+	}
+	return redactedURL(u)
+}
+
+// redactedURL returns the url with the whole user info redacted, as
+// the username may be a credential as much as the password, e.g. an
+// api key.
+func redactedURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	redacted := *u
+	redacted.User = url.User("xxxxx")
+	return redacted.String()
+}
+
+// parseURLError returns the url parse error without the url, which
+// may hold credentials.
+func parseURLError(err error) error {
+	var uErr *url.Error
+	if errors.As(err, &uErr) {
+		return uErr.Err
+	}
+	return err
+}
+
+// String returns the config with the credentials in the urls and
+// the bearer token redacted, so it is safe to log.
+func (c *Config) String() string {
+	type plain Config
+
+	redacted := plain(*c)
+	redacted.URL = redactURL(c.URL)
+	redacted.FallbackURLs = make([]string, len(c.FallbackURLs))
+	for i, u := range c.FallbackURLs {
+		redacted.FallbackURLs[i] = redactURL(u)
+	}
+	redacted.Devices = make([]Device, len(c.Devices))
+	for i, d := range c.Devices {
+		d.URL = redactURL(d.URL)
+		fallbacks := make([]string, len(d.FallbackURLs))
+		for j, u := range d.FallbackURLs {
+			fallbacks[j] = redactURL(u)
+		}
+		d.FallbackURLs = fallbacks
+		redacted.Devices[i] = d
+	}
+	if redacted.BearerToken != "" {
+		redacted.BearerToken = "xxxxx"
+	}
+	return fmt.Sprintf("%+v", redacted)
+}
+
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+func indexOf(vals []string, val string) int {
+	for i, v := range vals {
+		if v == val {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,115 @@
+package iotawatt
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Connection states of the devices, driven by the poll outcomes.
+const (
+	stateConnected    = "connected"
+	stateDegraded     = "degraded"
+	stateDisconnected = "disconnected"
+)
+
+// connection tracks the connection state from consecutive poll
+// failures.
+type connection struct {
+	degradedAfter     int
+	disconnectedAfter int
+
+	failures int
+	state    string
+}
+
+func newConnection(degradedAfter, disconnectedAfter int) *connection {
+	return &connection{
+		degradedAfter:     degradedAfter,
+		disconnectedAfter: disconnectedAfter,
+		state:             stateConnected,
+	}
+}
+
+// record records a poll outcome, returning the new state and whether
+// it changed. A single success reconnects.
+func (c *connection) record(err error) (string, bool) {
+	if err == nil {
+		c.failures = 0
+	} else {
+		c.failures++
+	}
+
+	state := stateConnected
+	switch {
+	case c.failures >= c.disconnectedAfter:
+		state = stateDisconnected
+	case c.failures >= c.degradedAfter:
+		state = stateDegraded
+	}
+	changed := state != c.state
+	c.state = state
+	return state, changed
+}
+
+// backoff returns the delay before the next poll, doubling the
+// interval for each failure while disconnected up to max. The
+// interval is returned while not disconnected or when max is zero.
+func (c *connection) backoff(interval, max time.Duration) time.Duration {
+	if c.state != stateDisconnected || max <= 0 {
+		return interval
+	}
+	delay := interval
+	for i := c.disconnectedAfter; i < c.failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// renderState sets the connection state class on the module element.
+func (m *Module) renderState(state string) error {
+	_, err := m.ui.Eval("document.querySelector('#%s .iotawatt').classList.remove('state-connected', 'state-degraded', 'state-disconnected'); "+
+		"document.querySelector('#%s .iotawatt').classList.add('state-%s')", m.name, m.name, state)
+	return err
+}
+
+// redactError returns the error message with the url user info and
+// the bearer token of the devices redacted.
+func (m *Module) redactError(err error) string {
+	msg := err.Error()
+	for _, d := range m.devices {
+		for _, u := range d.baseURLs {
+			if u.User == nil {
+				continue
+			}
+			msg = strings.ReplaceAll(msg, u.User.String()+"@", "xxxxx@")
+			if pass, ok := u.User.Password(); ok && pass != "" {
+				msg = strings.ReplaceAll(msg, pass, "xxxxx")
+			}
+		}
+	}
+	if m.cfg.BearerToken != "" {
+		msg = strings.ReplaceAll(msg, m.cfg.BearerToken, "xxxxx")
+	}
+	return msg
+}
+
+// renderError sets the poll error as the title of the module element,
+// shown as a tooltip, or removes the title when err is nil.
+func (m *Module) renderError(err error) error {
+	const docSelector = "document.querySelector('#%s .iotawatt')"
+
+	if err == nil {
+		_, err = m.ui.Eval(docSelector+".removeAttribute('title')", m.name)
+		return err
+	}
+	b, jErr := json.Marshal(m.redactError(err))
+	if jErr != nil {
+		return jErr
+	}
+	_, err = m.ui.Eval(docSelector+".title = %s", m.name, string(b))
+	return err
+}
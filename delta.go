@@ -0,0 +1,86 @@
+package iotawatt
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// pushed is the shape of the series last pushed to the chart.
+type pushed struct {
+	names  []string
+	hidden []bool
+	last   []float64
+}
+
+// newPushed returns the names, visibility and last timestamps of the series.
+// The last timestamp of a series without points is NaN.
+func newPushed(series []Series) *pushed {
+	p := &pushed{names: make([]string, len(series)), hidden: make([]bool, len(series)), last: make([]float64, len(series))}
+	for i, s := range series {
+		p.names[i] = s.Name
+		p.hidden[i] = s.hidden()
+		p.last[i] = math.NaN()
+		if len(s.Data) > 0 {
+			p.last[i] = s.Data[len(s.Data)-1][0]
+		}
+	}
+	return p
+}
+
+// seriesDelta returns the points of each series from the last pushed
+// timestamp on, along with the earliest timestamp still charted. The
+// last pushed point is sent again, as the device may have revised it.
+// It returns false if the series differ from those pushed, or there
+// are no points.
+func seriesDelta(series []Series, p *pushed) ([][]Point, float64, bool) {
+	if p == nil || len(series) != len(p.names) {
+		return nil, 0, false
+	}
+
+	delta := make([][]Point, len(series))
+	begin := math.Inf(1)
+	for i, s := range series {
+		if s.Name != p.names[i] || s.hidden() != p.hidden[i] {
+			return nil, 0, false
+		}
+		if len(s.Data) == 0 {
+			delta[i] = []Point{}
+			continue
+		}
+		begin = math.Min(begin, s.Data[0][0])
+
+		j := len(s.Data)
+		for j > 0 && (math.IsNaN(p.last[i]) || s.Data[j-1][0] >= p.last[i]) {
+			j--
+		}
+		delta[i] = s.Data[j:]
+	}
+	if math.IsInf(begin, 1) {
+		return nil, 0, false
+	}
+	return delta, begin, true
+}
+
+// renderDelta appends the points new since the last push to the chart,
+// returning false if a full update is needed instead, e.g. when the
+// series changed or the chart cannot be appended to.
+func (m *Module) renderDelta(series []Series) bool {
+	delta, begin, ok := seriesDelta(series, m.pushed)
+	if !ok {
+		return false
+	}
+	b, err := json.Marshal(delta)
+	if err != nil {
+		return false
+	}
+
+	// The delta is applied directly, as its result decides whether a
+	// full update is needed.
+	res, err := m.ui.Eval("%s(%s, %s)", m.jsName("appendChartData"), string(b), strconv.FormatFloat(begin, 'f', -1, 64))
+	if err != nil || res != true {
+		return false
+	}
+	m.pushed = newPushed(series)
+	return true
+}
@@ -0,0 +1,104 @@
+package iotawatt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesDelta(t *testing.T) {
+	hidden := false
+	prev := []Series{
+		{Name: "a", Data: []Point{{0, 1}, {20, 2}}},
+		{Name: "b", Data: []Point{}},
+	}
+
+	tests := []struct {
+		name      string
+		series    []Series
+		p         *pushed
+		want      [][]Point
+		wantBegin float64
+		wantOK    bool
+	}{
+		{
+			name: "new points from the last pushed",
+			series: []Series{
+				{Name: "a", Data: []Point{{20, 2}, {40, 3}, {60, 4}}},
+				{Name: "b", Data: []Point{{40, 5}}},
+			},
+			p:         newPushed(prev),
+			want:      [][]Point{{{20, 2}, {40, 3}, {60, 4}}, {{40, 5}}},
+			wantBegin: 20,
+			wantOK:    true,
+		},
+		{
+			name: "empty series",
+			series: []Series{
+				{Name: "a", Data: []Point{{20, 2}}},
+				{Name: "b"},
+			},
+			p:         newPushed(prev),
+			want:      [][]Point{{{20, 2}}, {}},
+			wantBegin: 20,
+			wantOK:    true,
+		},
+		{
+			name:   "nothing pushed",
+			series: prev,
+			p:      nil,
+		},
+		{
+			name:   "renamed series",
+			series: []Series{{Name: "a"}, {Name: "c", Data: []Point{{0, 1}}}},
+			p:      newPushed(prev),
+		},
+		{
+			name:   "visibility changed",
+			series: []Series{{Name: "a", Data: []Point{{0, 1}}, Visible: &hidden}, {Name: "b"}},
+			p:      newPushed(prev),
+		},
+		{
+			name:   "no points",
+			series: []Series{{Name: "a"}, {Name: "b"}},
+			p:      newPushed(prev),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, begin, ok := seriesDelta(test.series, test.p)
+
+			require.Equal(t, test.wantOK, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, test.want, got)
+			assert.Equal(t, test.wantBegin, begin)
+		})
+	}
+}
+
+func TestModule_RenderDelta(t *testing.T) {
+	m, ui, _ := newTestModule(t, NewConfig())
+	m.pushed = newPushed([]Series{{Name: "main", Data: []Point{{0, 1}, {20, 2}}}})
+	series := []Series{{Name: "main", Data: []Point{{20, 2}, {40, 3}}}}
+
+	ok := m.renderDelta(series)
+
+	require.True(t, ok)
+	assert.Equal(t, []string{`appendChartData_test([[[20,2],[40,3]]], 20)`}, ui.scripts())
+	assert.Equal(t, []float64{40}, m.pushed.last)
+}
+
+func TestModule_RenderDeltaNeedsFullUpdate(t *testing.T) {
+	m, ui, _ := newTestModule(t, NewConfig())
+	series := []Series{{Name: "main", Data: []Point{{20, 2}}}}
+
+	ok := m.renderDelta(series)
+
+	assert.False(t, ok)
+	assert.Empty(t, ui.scripts())
+	assert.Nil(t, m.pushed)
+}
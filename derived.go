@@ -0,0 +1,73 @@
+package iotawatt
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// derived is a value computed from two response columns.
+type derived struct {
+	name     string
+	num, den int
+}
+
+// newDerived resolves the derived metrics to their response columns.
+func newDerived(inputs []Input, metrics []Derived) ([]derived, error) {
+	cols := make(map[string]int, len(inputs))
+	for i, in := range inputs {
+		cols[in.Name] = i + 1
+	}
+
+	res := make([]derived, 0, len(metrics))
+	for _, d := range metrics {
+		switch d.Formula {
+		case "", "ratio":
+		default:
+			return nil, fmt.Errorf("derived %q has unsupported formula %q", d.Name, d.Formula)
+		}
+		if len(d.Inputs) != 2 {
+			return nil, fmt.Errorf("derived %q must have a numerator and denominator input", d.Name)
+		}
+		num, ok := cols[d.Inputs[0]]
+		if !ok {
+			return nil, fmt.Errorf("derived input %q is not a configured input", d.Inputs[0])
+		}
+		den, ok := cols[d.Inputs[1]]
+		if !ok {
+			return nil, fmt.Errorf("derived input %q is not a configured input", d.Inputs[1])
+		}
+		res = append(res, derived{name: d.Name, num: num, den: den})
+	}
+	return res, nil
+}
+
+// ratio returns num divided by den, or false if either is missing or
+// den is zero.
+func ratio(num, den float64) (float64, bool) {
+	if math.IsNaN(num) || math.IsNaN(den) || den == 0 {
+		return 0, false
+	}
+	return num / den, true
+}
+
+// renderDerived renders the derived metrics of the latest row, showing
+// a dash for a metric that cannot be computed.
+func (m *Module) renderDerived(raw [][]float64) error {
+	const docSelector = "document.querySelector('#%s .derived')"
+
+	vals := make([]string, 0, len(m.derived))
+	for _, d := range m.derived {
+		val := "&ndash;"
+		if len(raw) > 0 {
+			row := raw[len(raw)-1]
+			if v, ok := ratio(row[d.num], row[d.den]); ok {
+				val = strings.Replace(strconv.FormatFloat(v, 'f', 2, 64), ".", m.cfg.DecimalSeparator, 1)
+			}
+		}
+		vals = append(vals, d.name+" "+val)
+	}
+	_, err := m.eval(docSelector+".innerHTML = '%s'", m.name, strings.Join(vals, " &middot; "))
+	return err
+}
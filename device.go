@@ -0,0 +1,679 @@
+package iotawatt
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// device is an IoTaWatt device the module queries.
+type device struct {
+	baseURLs []*url.URL
+	qryVals  url.Values
+	inputs   int
+	// legacySelect is the select list in the format of firmware
+	// older than minQueryFirmware.
+	legacySelect string
+
+	// errLog samples the errors of a secondary device. It is only
+	// used with the fetch lock held.
+	errLog *logSampler
+}
+
+// reservedQueryParams are the query parameters the module relies on
+// to decode the response, which cannot be passed through.
+var reservedQueryParams = []string{"format", "select"}
+
+// windowPreset is a calendar window in the IoTaWatt relative time
+// syntax, evaluated by the device in its own time zone.
+type windowPreset struct {
+	begin, end string
+	// span is the longest the window can be.
+	span time.Duration
+}
+
+// windowPresets are the supported calendar windows.
+var windowPresets = map[string]windowPreset{
+	"lasthour":  {begin: "s-1h", end: "s", span: time.Hour},
+	"today":     {begin: "d", end: "s", span: 25 * time.Hour},
+	"yesterday": {begin: "d-1d", end: "d", span: 49 * time.Hour},
+	"thisweek":  {begin: "w", end: "s", span: 7*24*time.Hour + time.Hour},
+}
+
+// highResolutionWindow is the longest window queried at high
+// resolution when the resolution is picked automatically.
+const highResolutionWindow = 15 * time.Minute
+
+// queryResolution returns the query resolution for the window.
+// Short windows use the full resolution of the current log, while
+// longer windows use the low resolution the device serves from its
+// history log where it covers the window.
+func queryResolution(cfg *Config) string {
+	if cfg.Resolution != "auto" {
+		return cfg.Resolution
+	}
+	if cfg.span() <= highResolutionWindow {
+		return "high"
+	}
+	return "low"
+}
+
+// newDevice returns a device queried on the given urls for the inputs.
+func newDevice(cfg *Config, rawURLs []string, inputs []Input) (*device, error) {
+	qryValues := url.Values{
+		"format":     []string{cfg.Format},
+		"resolution": []string{queryResolution(cfg)},
+		"missing":    []string{cfg.Missing},
+		"begin":      []string{"s-" + strconv.Itoa(int(cfg.Window/time.Second)) + "s"},
+		"end":        []string{"s"},
+		"group":      []string{"auto"},
+	}
+	if p, ok := windowPresets[cfg.WindowPreset]; ok {
+		qryValues.Set("begin", p.begin)
+		qryValues.Set("end", p.end)
+	}
+	qryValues.Set("select", selectList(inputs, cfg.TimeColumn, false))
+	if cfg.Limit > 0 {
+		qryValues.Set("limit", strconv.Itoa(cfg.Limit))
+	}
+	for k, v := range cfg.QueryParams {
+		if contains(reservedQueryParams, k) {
+			continue
+		}
+		qryValues.Set(k, v)
+	}
+
+	baseURLs := make([]*url.URL, 0, len(rawURLs))
+	for _, rawURL := range rawURLs {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse url: %w", parseURLError(err))
+		}
+		baseURLs = append(baseURLs, u)
+	}
+
+	return &device{
+		baseURLs:     baseURLs,
+		qryVals:      qryValues,
+		inputs:       len(inputs),
+		legacySelect: selectList(inputs, cfg.TimeColumn, true),
+		errLog:       &logSampler{window: cfg.ErrorLogWindow},
+	}, nil
+}
+
+// selectList returns the query select list of the time and inputs. The
+// legacy format of firmware older than minQueryFirmware names the unit
+// of every input, as that firmware has no default unit.
+func selectList(inputs []Input, timeColumn string, legacy bool) string {
+	sel := make([]string, 0, len(inputs)+1)
+	for _, in := range inputs {
+		name := in.selectName()
+		if legacy && in.Unit == "" {
+			name += ".watts"
+		}
+		sel = append(sel, name)
+	}
+	if timeColumn == "last" {
+		sel = append(sel, "time.utc.unix")
+	} else {
+		sel = append([]string{"time.utc.unix"}, sel...)
+	}
+	return "[" + strings.Join(sel, ",") + "]"
+}
+
+// useLegacySelect queries the device with the legacy select format.
+// It is only called before polling starts.
+func (d *device) useLegacySelect() {
+	d.qryVals.Set("select", d.legacySelect)
+}
+
+// pollContext returns the context of a poll, with a deadline of the
+// interval shared by all its requests so a poll never outlasts the
+// interval. It is cancelled when the module is closed.
+func (m *Module) pollContext(reqID string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(withRequestID(context.Background(), reqID), m.interval)
+	go func() {
+		select {
+		case <-m.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// fetch requests the data from all devices concurrently, merging their
+// inputs onto a shared time axis. The inputs of a device that cannot be
+// reached are reported as missing.
+func (m *Module) fetch(ctx context.Context) ([][]float64, error) {
+	var qry func(*device) url.Values
+	if m.cfg.AlignWindow {
+		qry = m.alignedQuery(time.Now())
+	}
+
+	m.fetchMu.Lock()
+	defer m.fetchMu.Unlock()
+
+	return m.fetchDevices(ctx, qry)
+}
+
+// fetchDevices fetches the rows of all devices, using the query
+// values returned by qry for each device, or the device query values
+// when it is nil.
+func (m *Module) fetchDevices(ctx context.Context, qry func(*device) url.Values) ([][]float64, error) {
+	qryVals := func(d *device) url.Values {
+		if qry == nil {
+			return d.qryVals
+		}
+		return qry(d)
+	}
+
+	if len(m.devices) == 1 {
+		return m.fetchDevice(ctx, m.devices[0], qryVals(m.devices[0]))
+	}
+
+	results := make([][][]float64, len(m.devices))
+	errs := make([]error, len(m.devices))
+	var wg sync.WaitGroup
+	for i, d := range m.devices {
+		wg.Add(1)
+		go func(i int, d *device) {
+			defer wg.Done()
+
+			results[i], errs[i] = m.fetchDevice(ctx, d, qryVals(d))
+		}(i, d)
+	}
+	wg.Wait()
+
+	var ok bool
+	now := time.Now()
+	for i, err := range errs {
+		d := m.devices[i]
+		if err == nil {
+			if n := d.errLog.reset(); n > 0 {
+				m.log.Info("Suppressed similar device errors before recovering", m.logFields("fetchDevices", "device", i+1, "count", n)...)
+			}
+			ok = true
+			continue
+		}
+		if allow, n := d.errLog.allow(err.Error(), now); allow {
+			if n > 0 {
+				m.log.Info("Suppressed similar device errors", m.logFields("fetchDevices", "device", i+1, "count", n, "window", m.cfg.ErrorLogWindow.String())...)
+			}
+			m.log.Error("Could not get IoTaWatt device data", m.logFields("fetchDevices", "device", i+1, "requestId", requestID(ctx), "error", err)...)
+		}
+	}
+	if !ok {
+		return nil, errs[0]
+	}
+	return mergeDevices(m.devices, results, m.cfg.Duplicates, m.cfg.Alignment), nil
+}
+
+// checkConnection verifies each device can be queried, retrying
+// with backoff for the startup grace period.
+func (m *Module) checkConnection(ctx context.Context) error {
+	const maxBackoff = 30 * time.Second
+
+	deadline := time.Now().Add(m.cfg.StartupGrace)
+	backoff := time.Second
+	for {
+		err := m.connectDevices(ctx)
+		if err == nil || time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		m.log.Info("Could not connect to IoTaWatt, retrying", m.logFields("checkConnection", "delay", backoff.String(), "error", err)...)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("iotawatt: startup aborted: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// connectDevices queries each device once.
+func (m *Module) connectDevices(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, m.interval)
+	defer cancel()
+
+	for _, d := range m.devices {
+		if _, err := m.fetchDevice(ctx, d, d.qryVals); err != nil {
+			return fmt.Errorf("iotawatt: could not connect to %s: %w", redactedURL(d.baseURLs[0]), err)
+		}
+	}
+	return nil
+}
+
+// fetchDevice requests the data from each base url of the device in turn
+// until one succeeds. Retriable failures are retried once on the same url.
+func (m *Module) fetchDevice(ctx context.Context, d *device, qryVals url.Values) ([][]float64, error) {
+	var (
+		raw [][]float64
+		err error
+	)
+	for _, baseURL := range d.baseURLs {
+		raw, err = m.request(ctx, baseURL, qryVals)
+		if err != nil && isRetriable(err) && ctx.Err() == nil {
+			raw, err = m.request(ctx, baseURL, qryVals)
+		}
+		if err == nil {
+			break
+		}
+
+		if ctx.Err() != nil {
+			// A poll cancelled by Close has not run out of budget.
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && !m.closed() {
+				m.log.Error("Poll budget exhausted", m.logFields("fetchDevice", "budget", m.interval.String())...)
+			}
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.checkColumns(raw, d.inputs+1); err != nil {
+		return nil, fmt.Errorf("unexpected data: %w", err)
+	}
+	for i, row := range raw {
+		if m.cfg.TimeColumn == "last" {
+			// The rest of the module expects the time first.
+			raw[i] = append([]float64{row[d.inputs]}, row[:d.inputs]...)
+			continue
+		}
+		raw[i] = row[:d.inputs+1]
+	}
+	return raw, nil
+}
+
+// mergeDevices merges the rows of each device into rows holding the
+// inputs of all devices, aligned on their timestamps. Timestamps not
+// returned by every device are handled according to align: "pad"
+// reports the inputs of the absent devices as missing, "truncate"
+// drops the timestamp and "interpolate" fills the absent inputs
+// linearly from the neighbouring rows of the same device.
+func mergeDevices(devices []*device, results [][][]float64, dups, align string) [][]float64 {
+	width := 1
+	offsets := make([]int, len(devices))
+	for i, d := range devices {
+		offsets[i] = width
+		width += d.inputs
+	}
+
+	var want int
+	seen := map[float64]int{}
+	rows := map[float64][]float64{}
+	perDevice := make([][][]float64, len(results))
+	for i, raw := range results {
+		if raw == nil {
+			continue
+		}
+		want++
+		perDevice[i] = mergeDuplicates(raw, dups)
+		for _, row := range perDevice[i] {
+			merged, ok := rows[row[0]]
+			if !ok {
+				merged = make([]float64, width)
+				merged[0] = row[0]
+				for j := 1; j < width; j++ {
+					merged[j] = math.NaN()
+				}
+				rows[row[0]] = merged
+			}
+			seen[row[0]]++
+			copy(merged[offsets[i]:], row[1:])
+		}
+	}
+
+	raw := make([][]float64, 0, len(rows))
+	for ts, row := range rows {
+		if align == "truncate" && seen[ts] < want {
+			continue
+		}
+		raw = append(raw, row)
+	}
+	sort.Slice(raw, func(i, j int) bool { return raw[i][0] < raw[j][0] })
+
+	if align == "interpolate" {
+		for i, dev := range perDevice {
+			interpolateDevice(raw, dev, offsets[i], devices[i].inputs)
+		}
+	}
+	return raw
+}
+
+// interpolateDevice fills the inputs of a device in the merged rows
+// for timestamps the device did not return, interpolating linearly
+// between its surrounding rows. Timestamps outside of the range of
+// the device rows are left missing.
+func interpolateDevice(merged, dev [][]float64, offset, inputs int) {
+	if len(dev) < 2 {
+		return
+	}
+	sort.Slice(dev, func(i, j int) bool { return dev[i][0] < dev[j][0] })
+
+	for _, row := range merged {
+		ts := row[0]
+		idx := sort.Search(len(dev), func(i int) bool { return dev[i][0] >= ts })
+		if idx == 0 || idx == len(dev) || dev[idx][0] == ts {
+			continue
+		}
+		prev, next := dev[idx-1], dev[idx]
+		frac := (ts - prev[0]) / (next[0] - prev[0])
+		for j := 1; j <= inputs && j < len(prev) && j < len(next); j++ {
+			row[offset+j-1] = prev[j] + (next[j]-prev[j])*frac
+		}
+	}
+}
+
+// endpointURL returns the url of the api endpoint, appending
+// the endpoint to the base url path.
+func endpointURL(baseURL *url.URL, endpoint string) *url.URL {
+	u := *baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + endpoint
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return &u
+}
+
+// checkColumns verifies the width of every row matches the requested
+// select. Extra columns are ignored unless in strict mode, missing
+// columns are an error.
+func (m *Module) checkColumns(raw [][]float64, want int) error {
+	extra := 0
+	for _, row := range raw {
+		got := len(row)
+		switch {
+		case got == want:
+		case got < want || m.cfg.Strict:
+			return fmt.Errorf("expected %d columns, got %d", want, got)
+		case extra == 0:
+			extra = got
+		}
+	}
+	if extra > 0 {
+		m.log.Info("Response has extra columns, ignoring them", m.logFields("checkColumns", "expected", want, "got", extra)...)
+	}
+	return nil
+}
+
+// acquire blocks until fewer than the maximum concurrent requests are
+// in flight, returning the func releasing the request.
+func (m *Module) acquire(ctx context.Context) (func(), error) {
+	select {
+	case m.inflight <- struct{}{}:
+		return func() { <-m.inflight }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// wait blocks until the rate limit allows another request.
+func (m *Module) wait(ctx context.Context) error {
+	if m.limiter == nil {
+		return nil
+	}
+
+	r := m.limiter.Reserve()
+	d := r.Delay()
+	if d == 0 {
+		return nil
+	}
+	m.log.Info("Request rate limited, delaying request", m.logFields("wait", "delay", d.String())...)
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (m *Module) request(ctx context.Context, baseURL *url.URL, qryVals url.Values) ([][]float64, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, fmt.Errorf("request rate limited: %w", err)
+	}
+	release, err := m.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("request queued: %w", err)
+	}
+	defer release()
+
+	u := endpointURL(baseURL, apiQueryPath)
+	u.RawQuery = qryVals.Encode()
+
+	if m.timeout != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout.get())
+		defer cancel()
+	}
+
+	ctx, span := m.tracer.Start(ctx, "iotawatt.request")
+	defer span.End()
+
+	start := time.Now()
+	raw, status, err := m.doRequest(ctx, u)
+	if m.timeout != nil {
+		if timeout, changed := m.timeout.record(err); changed {
+			m.log.Info("Adjusted IoTaWatt request timeout", m.logFields("request", "timeout", timeout.String())...)
+		}
+	}
+
+	span.SetAttribute("url", redactedURL(u))
+	span.SetAttribute("requestId", requestID(ctx))
+	span.SetAttribute("status", status)
+	span.SetAttribute("rows", len(raw))
+	span.SetAttribute("duration", time.Since(start).String())
+	if err != nil {
+		span.RecordError(err)
+		return nil, &requestError{url: redactedURL(u), status: status, err: fmt.Errorf("query: %w", err)}
+	}
+	return raw, nil
+}
+
+// newRequest returns a GET request to the device url with the
+// configured authorization.
+func (m *Module) newRequest(ctx context.Context, u *url.URL) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if m.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+m.cfg.BearerToken)
+	}
+	if id := requestID(ctx); id != "" && m.cfg.RequestIDHeader != "" {
+		req.Header.Set(m.cfg.RequestIDHeader, id)
+	}
+	return req, nil
+}
+
+// doRequest queries the url, returning the decoded rows and the response status code.
+func (m *Module) doRequest(ctx context.Context, u *url.URL) ([][]float64, int, error) {
+	req, err := m.newRequest(ctx, u)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not create request: %w", err)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, 0, &retriableError{err: fmt.Errorf("could not request data: %w", err)}
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, resp.StatusCode, &throttledError{retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, resp.StatusCode, ErrNotFound
+	}
+	if resp.StatusCode != 200 {
+		return nil, resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	if err = checkContentType(resp, m.cfg.Format); err != nil {
+		return nil, resp.StatusCode, err
+	}
+
+	// The limit is exceeded by a byte so an oversized response can be told
+	// apart from one that is exactly the limit.
+	limited := io.LimitReader(resp.Body, m.cfg.MaxResponseSize+1)
+	body := &countingReader{r: limited}
+	if m.capture != nil {
+		var captured bytes.Buffer
+		body.r = io.TeeReader(limited, &captured)
+		defer func() { m.capture.add(redactedURL(u), resp.StatusCode, captured.Bytes()) }()
+	}
+	var raw [][]float64
+	if m.cfg.Format == "csv" {
+		raw, err = decodeCSV(body)
+	} else {
+		raw, err = decodeSeries(body, m.cfg.ResponseKey, m.cfg.StrictDecode)
+	}
+	m.metrics.addBytes(body.n)
+	if body.n > m.cfg.MaxResponseSize {
+		return nil, resp.StatusCode, fmt.Errorf("response exceeds %d bytes", m.cfg.MaxResponseSize)
+	}
+	if err != nil {
+		err = fmt.Errorf("could not parse data: %w", err)
+		if isTruncated(err) {
+			return nil, resp.StatusCode, &retriableError{err: err}
+		}
+		return nil, resp.StatusCode, err
+	}
+	return raw, resp.StatusCode, nil
+}
+
+// checkContentType verifies the response is in the requested format, returning
+// an error with the content type and the start of the body otherwise.
+func checkContentType(resp *http.Response, format string) error {
+	ct := resp.Header.Get("Content-Type")
+	if ct == "" {
+		return nil
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err == nil {
+		switch {
+		case format == "csv" && (mt == "text/csv" || mt == "text/plain"):
+			return nil
+		case format == "json" && (mt == "application/json" || strings.HasSuffix(mt, "+json")):
+			return nil
+		}
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 100))
+	return fmt.Errorf("expected %s response, got %q: %q", format, ct, snippet)
+}
+
+// decodeCSV decodes a csv query response. Empty and null
+// values are decoded as missing values.
+func decodeCSV(r io.Reader) ([][]float64, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([][]float64, 0, len(records))
+	for i, rec := range records {
+		row := make([]float64, len(rec))
+		for j, v := range rec {
+			if v == "" || v == "null" {
+				row[j] = math.NaN()
+				continue
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q in row %d: %w", v, i, err)
+			}
+			row[j] = f
+		}
+		raw = append(raw, row)
+	}
+	return raw, nil
+}
+
+// decodeSeries decodes the query response, which is either a bare
+// array of rows or an object holding the rows under key. Values in
+// exponent notation, e.g. 1e-05, are decoded like any other number;
+// the display always formats them in fixed point. When strict, an
+// object with other keys or data after the response is an error.
+func decodeSeries(r io.Reader, key string, strict bool) ([][]float64, error) {
+	var msg json.RawMessage
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(&msg); err != nil {
+		return nil, err
+	}
+	if strict {
+		if _, err := dec.Token(); err != io.EOF {
+			return nil, errors.New("unexpected data after response")
+		}
+	}
+
+	if b := bytes.TrimSpace(msg); len(b) > 0 && b[0] == '{' {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(b, &obj); err != nil {
+			return nil, err
+		}
+		data, ok := obj[key]
+		if !ok {
+			return nil, fmt.Errorf("response object has no %q key", key)
+		}
+		if strict && len(obj) > 1 {
+			for k := range obj {
+				if k != key {
+					return nil, fmt.Errorf("response object has unexpected %q key", k)
+				}
+			}
+		}
+		msg = data
+	}
+
+	// Decode the values as numbers so they are converted explicitly
+	// at full precision, and missing values can be distinguished.
+	dec = json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	var rows [][]*json.Number
+	if err := dec.Decode(&rows); err != nil {
+		return nil, err
+	}
+
+	raw := make([][]float64, len(rows))
+	for i, row := range rows {
+		raw[i] = make([]float64, len(row))
+		for j, v := range row {
+			if v == nil {
+				raw[i][j] = math.NaN()
+				continue
+			}
+			f, err := strconv.ParseFloat(v.String(), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q in row %d: %w", v.String(), i, err)
+			}
+			raw[i][j] = f
+		}
+	}
+	return raw, nil
+}
@@ -0,0 +1,721 @@
+package iotawatt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeSeries(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		strict  bool
+		want    [][]float64
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "array",
+			body:    `[[1614852000,1.5,null],[1614852020,2,3]]`,
+			want:    [][]float64{{1614852000, 1.5, nan}, {1614852020, 2, 3}},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "object",
+			body:    ` {"series":[[1614852000,1.5]]}`,
+			want:    [][]float64{{1614852000, 1.5}},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "scientific notation",
+			body:    `[[1614852000,1e-05,2.5E+3]]`,
+			want:    [][]float64{{1614852000, 0.00001, 2500}},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "object without key",
+			body:    `{"rows":[[1614852000,1.5]]}`,
+			wantErr: require.Error,
+		},
+		{
+			name:    "object with other keys",
+			body:    `{"series":[[1614852000,1.5]],"range":[1,2]}`,
+			want:    [][]float64{{1614852000, 1.5}},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "strict object with other keys",
+			body:    `{"series":[[1614852000,1.5]],"range":[1,2]}`,
+			strict:  true,
+			wantErr: require.Error,
+		},
+		{
+			name:    "strict object",
+			body:    `{"series":[[1614852000,1.5]]}`,
+			strict:  true,
+			want:    [][]float64{{1614852000, 1.5}},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "trailing data",
+			body:    `[[1614852000,1.5]] []`,
+			want:    [][]float64{{1614852000, 1.5}},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "strict trailing data",
+			body:    `[[1614852000,1.5]] []`,
+			strict:  true,
+			wantErr: require.Error,
+		},
+		{
+			name:    "invalid value",
+			body:    `[[1614852000,true]]`,
+			wantErr: require.Error,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeSeries(strings.NewReader(test.body), "series", test.strict)
+
+			test.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assertRows(t, test.want, got)
+		})
+	}
+}
+
+func TestModule_FetchDeviceExhaustsBudget(t *testing.T) {
+	var hits int32
+	slow := func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(150 * time.Millisecond):
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+	srv1 := httptest.NewServer(http.HandlerFunc(slow))
+	defer srv1.Close()
+	srv2 := httptest.NewServer(http.HandlerFunc(slow))
+	defer srv2.Close()
+
+	cfg := NewConfig()
+	cfg.URL = srv1.URL
+	cfg.FallbackURLs = []string{srv2.URL}
+	cfg.Interval = 200 * time.Millisecond
+	m, _, log := newTestModule(t, cfg)
+
+	ctx, cancel := m.pollContext("test")
+	defer cancel()
+	start := time.Now()
+
+	_, err := m.fetchDevice(ctx, m.devices[0], m.devices[0].qryVals)
+
+	require.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(300*time.Millisecond))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+	assert.Contains(t, log.errors(), "Poll budget exhausted")
+}
+
+func TestModule_FetchDeviceClosedIsNotOutOfBudget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Interval = time.Second
+	m, _, log := newTestModule(t, cfg)
+
+	ctx, cancel := m.pollContext("test")
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, func() { close(m.done) })
+
+	_, err := m.fetchDevice(ctx, m.devices[0], m.devices[0].qryVals)
+
+	require.Error(t, err)
+	assert.NotContains(t, log.errors(), "Poll budget exhausted")
+}
+
+func TestModule_CheckColumns(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      [][]float64
+		strict   bool
+		wantErr  require.ErrorAssertionFunc
+		wantLogs int
+	}{
+		{
+			name:    "matching rows",
+			raw:     [][]float64{{1, 2, 3}, {2, 4, 5}},
+			wantErr: require.NoError,
+		},
+		{
+			name:     "extra column",
+			raw:      [][]float64{{1, 2, 3, 4}, {2, 4, 5, 6}},
+			wantErr:  require.NoError,
+			wantLogs: 1,
+		},
+		{
+			name:    "strict extra column",
+			raw:     [][]float64{{1, 2, 3, 4}},
+			strict:  true,
+			wantErr: require.Error,
+		},
+		{
+			name:    "short row after a wide row",
+			raw:     [][]float64{{1, 2, 3, 4}, {2, 5}},
+			wantErr: require.Error,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Strict = test.strict
+			m, _, log := newTestModule(t, cfg)
+
+			err := m.checkColumns(test.raw, 3)
+
+			test.wantErr(t, err)
+			if err == nil {
+				assert.Len(t, log.infos(), test.wantLogs)
+			}
+		})
+	}
+}
+
+func TestModule_RequestRejectsHTML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = rw.Write([]byte("<html><body>Router login</body></html>"))
+	}))
+	defer srv.Close()
+
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	m, _, _ := newTestModule(t, cfg)
+
+	_, err := m.request(context.Background(), m.devices[0].baseURLs[0], m.devices[0].qryVals)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `got "text/html; charset=utf-8"`)
+	assert.Contains(t, err.Error(), "Router login")
+}
+
+func TestModule_RequestKeepAlive(t *testing.T) {
+	tests := []struct {
+		name      string
+		disable   bool
+		wantClose bool
+	}{
+		{
+			name:      "keep alive",
+			wantClose: false,
+		},
+		{
+			name:      "keep alive disabled",
+			disable:   true,
+			wantClose: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gotClose bool
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				gotClose = r.Close
+				rw.Header().Set("Content-Type", "application/json")
+				_, _ = rw.Write([]byte(`[[1614852000,1]]`))
+			}))
+			defer srv.Close()
+
+			cfg := NewConfig()
+			cfg.URL = srv.URL
+			cfg.DisableKeepAlives = test.disable
+			m, _, _ := newTestModule(t, cfg)
+
+			_, err := m.request(context.Background(), m.devices[0].baseURLs[0], m.devices[0].qryVals)
+
+			require.NoError(t, err)
+			assert.Equal(t, test.wantClose, gotClose)
+		})
+	}
+}
+
+func TestModule_RequestTruncatedBodyIsRetriable(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&hits, 1) == 1 {
+			// The connection is closed before the promised length.
+			rw.Header().Set("Content-Length", "100")
+			_, _ = rw.Write([]byte(`[[1614852000,1`))
+			return
+		}
+		_, _ = rw.Write([]byte(`[[1614852000,1]]`))
+	}))
+	defer srv.Close()
+
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	m, _, _ := newTestModule(t, cfg)
+	d := m.devices[0]
+
+	_, err := m.request(context.Background(), d.baseURLs[0], d.qryVals)
+	require.Error(t, err)
+	assert.True(t, isRetriable(err))
+
+	atomic.StoreInt32(&hits, 0)
+	raw, err := m.fetchDevice(context.Background(), d, d.qryVals)
+	require.NoError(t, err)
+	assertRows(t, [][]float64{{1614852000, 1}}, raw)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+}
+
+func TestModule_FetchDevicesWithDeviceDown(t *testing.T) {
+	main := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`[[1614852000,1],[1614852020,2]]`))
+	}))
+	defer main.Close()
+	sub := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer sub.Close()
+
+	cfg := NewConfig()
+	cfg.URL = main.URL
+	cfg.Devices = []Device{{URL: sub.URL, Inputs: []Input{{Name: "solar"}}}}
+	m, _, log := newTestModule(t, cfg)
+
+	raw, err := m.fetchDevices(context.Background(), nil)
+
+	require.NoError(t, err)
+	assertRows(t, [][]float64{{1614852000, 1, nan}, {1614852020, 2, nan}}, raw)
+	assert.Contains(t, log.errors(), "Could not get IoTaWatt device data")
+}
+
+func TestEndpointURL(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+		want string
+	}{
+		{
+			name: "without trailing slash",
+			base: "http://iotawatt.local",
+			want: "http://iotawatt.local/query",
+		},
+		{
+			name: "with trailing slash",
+			base: "http://iotawatt.local/",
+			want: "http://iotawatt.local/query",
+		},
+		{
+			name: "with subpath",
+			base: "http://proxy.local/iotawatt",
+			want: "http://proxy.local/iotawatt/query",
+		},
+		{
+			name: "with subpath and trailing slash",
+			base: "http://proxy.local/iotawatt/",
+			want: "http://proxy.local/iotawatt/query",
+		},
+		{
+			name: "drops query and fragment",
+			base: "http://iotawatt.local/?a=b#c",
+			want: "http://iotawatt.local/query",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base, err := url.Parse(test.base)
+			require.NoError(t, err)
+
+			got := endpointURL(base, "query")
+
+			assert.Equal(t, test.want, got.String())
+			assert.Equal(t, test.base, base.String())
+		})
+	}
+}
+
+func TestModule_RequestIsRateLimited(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		rw.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/status" {
+			_, _ = rw.Write([]byte(`{"device":{"version":"02_07_05"},"datalogs":[{"id":"Current","interval":5}]}`))
+			return
+		}
+		_, _ = rw.Write([]byte(`[[1614852000,500]]`))
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.MaxRequestsPerMinute = 600
+	m, _, log := newTestModule(t, cfg)
+	base := m.devices[0].baseURLs[0]
+
+	start := time.Now()
+	_, err := m.request(context.Background(), base, nil)
+	require.NoError(t, err)
+	_, err = m.firmware(context.Background())
+	require.NoError(t, err)
+	_, err = m.datalogInterval(context.Background())
+	require.NoError(t, err)
+	elapsed := time.Since(start)
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+	assert.GreaterOrEqual(t, elapsed, 190*time.Millisecond)
+	assert.Contains(t, log.infos(), "Request rate limited, delaying request")
+}
+
+func TestModule_StatusWaitsForSlot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"device":{"version":"02_07_05"}}`))
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.MaxConcurrentRequests = 1
+	m, _, _ := newTestModule(t, cfg)
+	release, err := m.acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = m.firmware(ctx)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestDecodeCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    [][]float64
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "rows",
+			body:    "1614852000, 1.5,null\n1614852020,,2\n",
+			want:    [][]float64{{1614852000, 1.5, nan}, {1614852020, nan, 2}},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "ragged rows",
+			body:    "1614852000,1.5\n1614852020\n",
+			want:    [][]float64{{1614852000, 1.5}, {1614852020}},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "invalid value",
+			body:    "1614852000,abc\n",
+			wantErr: require.Error,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeCSV(strings.NewReader(test.body))
+
+			test.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assertRows(t, test.want, got)
+		})
+	}
+}
+
+func TestMergeDevices(t *testing.T) {
+	devices := []*device{{inputs: 1}, {inputs: 2}}
+	results := func() [][][]float64 {
+		return [][][]float64{
+			{{0, 1}, {20, 2}, {40, 3}},
+			{{0, 10, 100}, {40, 30, 300}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		results [][][]float64
+		align   string
+		want    [][]float64
+	}{
+		{
+			name:    "pad",
+			results: results(),
+			align:   "pad",
+			want:    [][]float64{{0, 1, 10, 100}, {20, 2, nan, nan}, {40, 3, 30, 300}},
+		},
+		{
+			name:    "truncate",
+			results: results(),
+			align:   "truncate",
+			want:    [][]float64{{0, 1, 10, 100}, {40, 3, 30, 300}},
+		},
+		{
+			name:    "interpolate",
+			results: results(),
+			align:   "interpolate",
+			want:    [][]float64{{0, 1, 10, 100}, {20, 2, 20, 200}, {40, 3, 30, 300}},
+		},
+		{
+			name:    "failed device",
+			results: [][][]float64{{{0, 1}, {20, 2}}, nil},
+			align:   "truncate",
+			want:    [][]float64{{0, 1, nan, nan}, {20, 2, nan, nan}},
+		},
+		{
+			name:    "duplicates",
+			results: [][][]float64{{{0, 1}, {0, 3}}, {{0, 10, 100}}},
+			align:   "pad",
+			want:    [][]float64{{0, 2, 10, 100}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := mergeDevices(devices, test.results, "average", test.align)
+
+			assertRows(t, test.want, got)
+		})
+	}
+}
+
+func TestModule_RequestEncodesSelect(t *testing.T) {
+	var sel string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		sel = r.URL.Query().Get("select")
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`[[1614852000,1,2,3]]`))
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{
+		{Name: "Air Conditioner"},
+		{Name: "Pool & Spa?", Unit: "Watts"},
+		{Name: "Ünit #2"},
+	}
+	m, _, _ := newTestModule(t, cfg)
+	d := m.devices[0]
+
+	raw, err := m.request(context.Background(), d.baseURLs[0], d.qryVals)
+
+	require.NoError(t, err)
+	assert.Equal(t, "[time.utc.unix,Air Conditioner,Pool & Spa?.Watts,Ünit #2]", sel)
+	assert.Len(t, raw, 1)
+}
+
+func TestModule_CheckConnectionWaitsForDevice(t *testing.T) {
+	up := time.Now().Add(500 * time.Millisecond)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if time.Now().Before(up) {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`[[1614852000,500]]`))
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.StartupGrace = 10 * time.Second
+	m, _, log := newTestModule(t, cfg)
+
+	err := m.checkConnection(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Could not connect to IoTaWatt, retrying"}, log.infos())
+}
+
+func TestModule_CheckConnectionWithoutGrace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	m, _, _ := newTestModule(t, cfg)
+
+	err := m.checkConnection(context.Background())
+
+	assert.Error(t, err)
+}
+
+func TestModule_CheckConnectionAborted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.StartupGrace = 10 * time.Second
+	m, _, _ := newTestModule(t, cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := m.checkConnection(ctx)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "startup aborted")
+}
+
+func TestModule_RequestSendsBearerToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{
+			name:  "token",
+			token: "secret-token",
+			want:  "Bearer secret-token",
+		},
+		{
+			name: "no token",
+			want: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var auth []string
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				auth = append(auth, r.Header.Get("Authorization"))
+				rw.Header().Set("Content-Type", "application/json")
+				if r.URL.Path == "/status" {
+					_, _ = rw.Write([]byte(`{"device":{"version":"02_07_05"}}`))
+					return
+				}
+				_, _ = rw.Write([]byte(`[[1614852000,500]]`))
+			}))
+			defer srv.Close()
+			cfg := NewConfig()
+			cfg.URL = srv.URL
+			cfg.BearerToken = test.token
+			m, _, _ := newTestModule(t, cfg)
+
+			_, err := m.request(context.Background(), m.devices[0].baseURLs[0], nil)
+			require.NoError(t, err)
+			_, err = m.firmware(context.Background())
+			require.NoError(t, err)
+
+			assert.Equal(t, []string{test.want, test.want}, auth)
+		})
+	}
+}
+
+func TestModule_FetchDevicesSamplesDeviceErrors(t *testing.T) {
+	var down int32 = 1
+	main := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`[[1614852000,500]]`))
+	}))
+	defer main.Close()
+	second := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`[[1614852000,200]]`))
+	}))
+	defer second.Close()
+	cfg := NewConfig()
+	cfg.URL = main.URL
+	cfg.Devices = []Device{{URL: second.URL, Inputs: []Input{{Name: "solar"}}}}
+	cfg.ErrorLogWindow = time.Hour
+	m, _, log := newTestModule(t, cfg)
+
+	for i := 0; i < 5; i++ {
+		_, err := m.fetchDevices(context.Background(), nil)
+		require.NoError(t, err)
+	}
+	atomic.StoreInt32(&down, 0)
+	_, err := m.fetchDevices(context.Background(), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"Could not get IoTaWatt device data"}, log.errors())
+	assert.Equal(t, []string{"Suppressed similar device errors before recovering"}, log.infos())
+}
+
+func TestModule_RequestErrorRedactsUserInfo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = strings.Replace(srv.URL, "http://", "http://admin:secret@", 1)
+	m, _, _ := newTestModule(t, cfg)
+	d := m.devices[0]
+
+	_, err := m.request(context.Background(), d.baseURLs[0], d.qryVals)
+	connErr := m.connectDevices(context.Background())
+
+	var rErr *requestError
+	require.ErrorAs(t, err, &rErr)
+	assert.Contains(t, rErr.url, "xxxxx@")
+	assert.NotContains(t, rErr.url, "admin")
+	assert.NotContains(t, rErr.url, "secret")
+	require.Error(t, connErr)
+	assert.Contains(t, connErr.Error(), "xxxxx@")
+	assert.NotContains(t, connErr.Error(), "admin")
+	assert.NotContains(t, connErr.Error(), "secret")
+}
+
+func TestModule_FetchDevicesLimitsInFlight(t *testing.T) {
+	var inFlight, maxInFlight int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`[[1614852000,1]]`))
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.MaxConcurrentRequests = 2
+	for i := 1; i <= 4; i++ {
+		cfg.Devices = append(cfg.Devices, Device{URL: srv.URL, Inputs: []Input{{Name: "device" + strconv.Itoa(i)}}})
+	}
+	m, _, _ := newTestModule(t, cfg)
+	ctx, cancel := m.pollContext("test")
+	defer cancel()
+
+	raw, err := m.fetchDevices(ctx, nil)
+
+	require.NoError(t, err)
+	assertRows(t, [][]float64{{1614852000, 1, 1, 1, 1, 1}}, raw)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxInFlight))
+}
@@ -0,0 +1,83 @@
+package iotawatt
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the device query endpoint is not found,
+// which usually means the configured url or its path is wrong.
+var ErrNotFound = errors.New("query endpoint not found, check the url points at the device and includes any proxy path")
+
+// retriableError is a transient error, such as a network failure
+// or truncated response, where the request can be retried.
+type retriableError struct {
+	err error
+}
+
+func (e *retriableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retriableError) Unwrap() error {
+	return e.err
+}
+
+// isRetriable determines if the request failing with err can be retried.
+func isRetriable(err error) bool {
+	var rErr *retriableError
+	return errors.As(err, &rErr)
+}
+
+// isTruncated determines if the decode error is caused by a truncated body.
+func isTruncated(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// requestError is a failed device request, with the redacted url
+// and the response status code, if there was a response.
+type requestError struct {
+	url    string
+	status int
+	err    error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// throttledError is returned when the device throttles requests.
+type throttledError struct {
+	retryAfter time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return "requests throttled, retry after " + e.retryAfter.String()
+}
+
+// parseRetryAfter parses a Retry-After header value, either in
+// seconds or as an http date, into a delay relative to now.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
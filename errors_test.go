@@ -0,0 +1,58 @@
+package iotawatt
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		v    string
+		want time.Duration
+	}{
+		{
+			name: "empty",
+			v:    "",
+			want: 0,
+		},
+		{
+			name: "seconds",
+			v:    " 120 ",
+			want: 2 * time.Minute,
+		},
+		{
+			name: "negative seconds",
+			v:    "-5",
+			want: 0,
+		},
+		{
+			name: "http date",
+			v:    now.Add(90 * time.Second).Format(http.TimeFormat),
+			want: 90 * time.Second,
+		},
+		{
+			name: "past http date",
+			v:    now.Add(-time.Minute).Format(http.TimeFormat),
+			want: 0,
+		},
+		{
+			name: "invalid",
+			v:    "soon",
+			want: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseRetryAfter(test.v, now)
+
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package iotawatt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// event is a timestamped device event shown on the chart.
+type event struct {
+	Time float64 `json:"time"`
+	Text string  `json:"text"`
+}
+
+// errEventsUnsupported is returned when the device has no events endpoint.
+var errEventsUnsupported = errors.New("events are not supported by the device")
+
+// fetchEvents returns the events of the main device between begin
+// and end, within the poll deadline.
+func (m *Module) fetchEvents(ctx context.Context, begin, end float64) ([]event, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, fmt.Errorf("request rate limited: %w", err)
+	}
+	release, err := m.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("request queued: %w", err)
+	}
+	defer release()
+
+	u := endpointURL(m.devices[0].baseURLs[0], m.cfg.EventsEndpoint)
+	u.RawQuery = url.Values{
+		"begin": []string{m.devices[0].qryVals.Get("begin")},
+		"end":   []string{m.devices[0].qryVals.Get("end")},
+	}.Encode()
+
+	req, err := m.newRequest(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not request events: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return nil, errEventsUnsupported
+	default:
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var evts []event
+	if err = json.NewDecoder(resp.Body).Decode(&evts); err != nil {
+		return nil, fmt.Errorf("could not parse events: %w", err)
+	}
+
+	inWindow := evts[:0]
+	for _, e := range evts {
+		if e.Time < begin || e.Time > end {
+			continue
+		}
+		inWindow = append(inWindow, e)
+	}
+	return inWindow, nil
+}
+
+// updateEvents fetches the device events in the window and draws
+// them on the chart. Devices without events disable the feature.
+func (m *Module) updateEvents(ctx context.Context, begin, end float64) {
+	evts, err := m.fetchEvents(ctx, begin, end)
+	if errors.Is(err, errEventsUnsupported) {
+		m.log.Info("IoTaWatt events are not supported, disabling events", m.logFields("updateEvents")...)
+		m.noEvents = true
+		return
+	}
+	if err != nil {
+		m.log.Error("Could not get IoTaWatt events", m.logFields("updateEvents", "error", err)...)
+		return
+	}
+
+	lines := make([]plotLine, 0, len(evts))
+	for _, e := range evts {
+		ts := e.Time
+		if m.cfg.TimestampMillis {
+			ts *= 1000
+		}
+		lines = append(lines, plotLine{
+			Value:  ts,
+			Color:  m.cfg.EventColor,
+			Width:  1,
+			ZIndex: 3,
+			Label: plotLineLabel{
+				Text:  e.Text,
+				Style: map[string]string{"color": m.cfg.EventColor},
+			},
+		})
+	}
+
+	b, err := json.Marshal(lines)
+	if err != nil {
+		m.log.Error("Could not encode events", m.logFields("updateEvents", "error", err)...)
+		return
+	}
+	if _, err = m.eval("%s(%s)", m.jsName("updateEvents"), string(b)); err != nil {
+		m.log.Error("Could not update events", m.logFields("updateEvents", "error", err)...)
+	}
+}
@@ -0,0 +1,33 @@
+package iotawatt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModule_FetchEventsUsesPollDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.EventsEndpoint = "events"
+	m, _, _ := newTestModule(t, cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+
+	_, err := m.fetchEvents(ctx, 0, 1)
+
+	require.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
@@ -0,0 +1,56 @@
+package iotawatt
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ExportCSV writes the last successfully fetched series as csv, with
+// a time column followed by a column per series headed by its legend
+// name. Missing values are written as empty cells.
+func (m *Module) ExportCSV(w io.Writer) error {
+	snap := m.latest.get()
+	if snap == nil {
+		return errors.New("iotawatt: no data available")
+	}
+
+	header := make([]string, 0, len(snap.Series)+1)
+	header = append(header, "time")
+	rows := map[float64][]string{}
+	for i, s := range snap.Series {
+		header = append(header, s.Name)
+		for _, p := range s.Data {
+			row, ok := rows[p[0]]
+			if !ok {
+				row = make([]string, len(snap.Series)+1)
+				row[0] = strconv.FormatFloat(p[0], 'f', -1, 64)
+				rows[p[0]] = row
+			}
+			if !math.IsNaN(p[1]) {
+				row[i+1] = strconv.FormatFloat(p[1], 'f', -1, 64)
+			}
+		}
+	}
+
+	times := make([]float64, 0, len(rows))
+	for ts := range rows {
+		times = append(times, ts)
+	}
+	sort.Float64s(times)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, ts := range times {
+		if err := cw.Write(rows[ts]); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
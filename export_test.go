@@ -0,0 +1,37 @@
+package iotawatt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModule_ExportCSV(t *testing.T) {
+	m, _, _ := newTestModule(t, NewConfig())
+	m.latest.set(1.5, []Series{
+		{Name: "Mains (W)", Data: []Point{{40, 2}, {20, 1.5}}},
+		{Name: "Line, V (V)", Data: []Point{{20, 230}, {40, nan}, {60, 231}}},
+	})
+	var buf strings.Builder
+
+	err := m.ExportCSV(&buf)
+
+	require.NoError(t, err)
+	want := "time,Mains (W),\"Line, V (V)\"\n" +
+		"20,1.5,230\n" +
+		"40,2,\n" +
+		"60,,231\n"
+	assert.Equal(t, want, buf.String())
+}
+
+func TestModule_ExportCSVWithoutData(t *testing.T) {
+	m, _, _ := newTestModule(t, NewConfig())
+	var buf strings.Builder
+
+	err := m.ExportCSV(&buf)
+
+	assert.Error(t, err)
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,77 @@
+package iotawatt
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// minQueryFirmware is the oldest firmware serving the current query
+// select format. Older firmware is queried in the legacy format.
+var minQueryFirmware = firmwareVersion{2, 5, 0}
+
+// firmwareVersion is an IoTaWatt firmware version, e.g. "02_07_05".
+type firmwareVersion [3]int
+
+// parseFirmware parses a firmware version of underscore or dot
+// separated numbers.
+func parseFirmware(s string) (firmwareVersion, error) {
+	var v firmwareVersion
+	parts := strings.FieldsFunc(strings.TrimSpace(s), func(r rune) bool { return r == '_' || r == '.' })
+	if len(parts) == 0 || len(parts) > len(v) {
+		return v, fmt.Errorf("invalid firmware version %q", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid firmware version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// before returns if the version is older than o.
+func (v firmwareVersion) before(o firmwareVersion) bool {
+	for i := range v {
+		if v[i] != o[i] {
+			return v[i] < o[i]
+		}
+	}
+	return false
+}
+
+// firmware reads the firmware version of the main device.
+func (m *Module) firmware(ctx context.Context) (string, error) {
+	status, err := m.readStatus(ctx, "device=yes")
+	if err != nil {
+		return "", err
+	}
+	if status.Device.Version == "" {
+		return "", fmt.Errorf("status has no firmware version")
+	}
+	return status.Device.Version, nil
+}
+
+// detectFirmware reads and records the firmware version of the main
+// device, switching it to the legacy select format when it predates
+// minQueryFirmware. The current format is kept when the version cannot
+// be determined.
+func (m *Module) detectFirmware(ctx context.Context) error {
+	version, err := m.firmware(ctx)
+	if err != nil {
+		return err
+	}
+	m.metrics.setFirmware(version)
+
+	v, err := parseFirmware(version)
+	if err != nil {
+		return err
+	}
+	if v.before(minQueryFirmware) {
+		m.log.Info("IoTaWatt firmware predates the current query format, using the legacy select format", m.logFields("detectFirmware", "firmware", version)...)
+		m.devices[0].useLegacySelect()
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package iotawatt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFirmware(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    firmwareVersion
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "underscores",
+			version: "02_07_05",
+			want:    firmwareVersion{2, 7, 5},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "dots",
+			version: " 2.5 ",
+			want:    firmwareVersion{2, 5, 0},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "empty",
+			wantErr: require.Error,
+		},
+		{
+			name:    "too many parts",
+			version: "02_07_05_01",
+			wantErr: require.Error,
+		},
+		{
+			name:    "not a number",
+			version: "02_x_05",
+			wantErr: require.Error,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseFirmware(test.version)
+
+			test.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestFirmwareVersion_Before(t *testing.T) {
+	assert.True(t, firmwareVersion{2, 4, 9}.before(minQueryFirmware))
+	assert.True(t, firmwareVersion{1, 9, 9}.before(minQueryFirmware))
+	assert.False(t, firmwareVersion{2, 5, 0}.before(minQueryFirmware))
+	assert.False(t, firmwareVersion{3, 0, 0}.before(minQueryFirmware))
+}
+
+func TestModule_DetectFirmwareSelectFormat(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		wantSelect   string
+		wantFirmware string
+		wantErr      require.ErrorAssertionFunc
+	}{
+		{
+			name:         "current firmware",
+			status:       http.StatusOK,
+			body:         `{"device":{"version":"02_07_05"}}`,
+			wantSelect:   "[time.utc.unix,main,Line.Volts]",
+			wantFirmware: "02_07_05",
+			wantErr:      require.NoError,
+		},
+		{
+			name:         "legacy firmware",
+			status:       http.StatusOK,
+			body:         `{"device":{"version":"02_04_01"}}`,
+			wantSelect:   "[time.utc.unix,main.watts,Line.Volts]",
+			wantFirmware: "02_04_01",
+			wantErr:      require.NoError,
+		},
+		{
+			name:         "unparsable version",
+			status:       http.StatusOK,
+			body:         `{"device":{"version":"unknown"}}`,
+			wantSelect:   "[time.utc.unix,main,Line.Volts]",
+			wantFirmware: "unknown",
+			wantErr:      require.Error,
+		},
+		{
+			name:       "no version",
+			status:     http.StatusOK,
+			body:       `{"device":{}}`,
+			wantSelect: "[time.utc.unix,main,Line.Volts]",
+			wantErr:    require.Error,
+		},
+		{
+			name:       "status unavailable",
+			status:     http.StatusNotFound,
+			wantSelect: "[time.utc.unix,main,Line.Volts]",
+			wantErr:    require.Error,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var sel string
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				rw.Header().Set("Content-Type", "application/json")
+				if r.URL.Path == "/status" {
+					rw.WriteHeader(test.status)
+					_, _ = rw.Write([]byte(test.body))
+					return
+				}
+				sel = r.URL.Query().Get("select")
+				_, _ = rw.Write([]byte(`[[1614852000,1,2]]`))
+			}))
+			defer srv.Close()
+			cfg := NewConfig()
+			cfg.URL = srv.URL
+			cfg.Inputs = []Input{{Name: "main"}, {Name: "Line", Unit: "Volts"}}
+			m, _, _ := newTestModule(t, cfg)
+			d := m.devices[0]
+
+			err := m.detectFirmware(context.Background())
+
+			test.wantErr(t, err)
+			_, err = m.request(context.Background(), d.baseURLs[0], d.qryVals)
+			require.NoError(t, err)
+			assert.Equal(t, test.wantSelect, sel)
+			assert.Equal(t, test.wantFirmware, m.Status().Firmware)
+		})
+	}
+}
+
+func TestSelectList(t *testing.T) {
+	inputs := []Input{{Name: "main"}, {Name: "Solar", Unit: "Watts"}}
+
+	tests := []struct {
+		name       string
+		timeColumn string
+		legacy     bool
+		want       string
+	}{
+		{
+			name:       "current",
+			timeColumn: "first",
+			want:       "[time.utc.unix,main,Solar.Watts]",
+		},
+		{
+			name:       "legacy",
+			timeColumn: "first",
+			legacy:     true,
+			want:       "[time.utc.unix,main.watts,Solar.Watts]",
+		},
+		{
+			name:       "legacy time last",
+			timeColumn: "last",
+			legacy:     true,
+			want:       "[main.watts,Solar.Watts,time.utc.unix]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := selectList(inputs, test.timeColumn, test.legacy)
+
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
@@ -3,3 +3,15 @@ module github.com/glasslabs/iotawatt
 go 1.17
 
 require github.com/glasslabs/looking-glass v0.2.0
+
+require (
+	github.com/stretchr/testify v1.7.0
+	go.uber.org/goleak v1.1.12
+	golang.org/x/time v0.3.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+)
@@ -0,0 +1,75 @@
+package iotawatt
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// snapshot is the last successfully fetched data.
+type snapshot struct {
+	Time    time.Time `json:"time"`
+	Current *float64  `json:"current"`
+	Series  []Series  `json:"series"`
+}
+
+// latest holds the last successfully fetched data. The series are
+// copied into buffers reused by every set, so get returns a copy.
+type latest struct {
+	mu     sync.Mutex
+	snap   *snapshot
+	series []Series
+	points []Point
+}
+
+func (l *latest) set(current float64, s []Series) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.series, l.points = copySeries(l.series, l.points, s)
+	snap := &snapshot{Time: time.Now(), Series: l.series}
+	if !math.IsNaN(current) && !math.IsInf(current, 0) {
+		snap.Current = &current
+	}
+	l.snap = snap
+}
+
+func (l *latest) get() *snapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.snap == nil {
+		return nil
+	}
+	snap := *l.snap
+	snap.Series, _ = copySeries(nil, nil, l.snap.Series)
+	return &snap
+}
+
+// Handler returns an http handler serving the last successfully
+// fetched series and current total as json.
+func (m *Module) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			rw.Header().Set("Allow", "GET, HEAD")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		snap := m.latest.get()
+		if snap == nil {
+			http.Error(rw, "no data available", http.StatusServiceUnavailable)
+			return
+		}
+
+		b, err := json.Marshal(snap)
+		if err != nil {
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write(b)
+	})
+}
@@ -0,0 +1,30 @@
+package iotawatt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatest_KeepsCopies(t *testing.T) {
+	var l latest
+	series := []Series{{Name: "main", Data: []Point{{20, 1}}}}
+
+	l.set(1, series)
+	series[0].Data[0] = Point{20, 2}
+	snap := l.get()
+	snap.Series[0].Data[0] = Point{20, 3}
+
+	got := l.get()
+	require.NotNil(t, got)
+	require.NotNil(t, got.Current)
+	assert.Equal(t, 1.0, *got.Current)
+	assertPoints(t, []Point{{20, 1}}, got.Series[0].Data)
+}
+
+func TestLatest_Empty(t *testing.T) {
+	var l latest
+
+	assert.Nil(t, l.get())
+}
@@ -0,0 +1,47 @@
+package iotawatt
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// historyRange returns the unix begin and end of the window of the
+// given duration ending at before.
+func historyRange(before time.Time, duration time.Duration) (begin, end int64) {
+	end = before.Unix()
+	return end - int64(duration/time.Second), end
+}
+
+// LoadHistory fetches the series of the window of the given duration
+// ending at before, e.g. for the front-end to prepend when the chart
+// is panned back in time. It waits for any poll in progress, so the
+// requests to the devices never overlap.
+func (m *Module) LoadHistory(ctx context.Context, before time.Time, duration time.Duration) ([]Series, error) {
+	if duration < time.Second {
+		return nil, errors.New("iotawatt: history duration must be at least 1s")
+	}
+	begin, end := historyRange(before, duration)
+
+	qry := func(d *device) url.Values {
+		vals := make(url.Values, len(d.qryVals))
+		for k, v := range d.qryVals {
+			vals[k] = v
+		}
+		vals.Set("begin", strconv.FormatInt(begin, 10))
+		vals.Set("end", strconv.FormatInt(end, 10))
+		return vals
+	}
+
+	m.fetchMu.Lock()
+	raw, err := m.fetchDevices(ctx, qry)
+	m.fetchMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	series, _ := m.buildSeries(m.prepareRows(raw, before.Add(-duration), before))
+	return series, nil
+}
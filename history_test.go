@@ -0,0 +1,97 @@
+package iotawatt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryRange(t *testing.T) {
+	before := time.Unix(1614852000, 0)
+
+	begin, end := historyRange(before, time.Hour)
+
+	assert.Equal(t, int64(1614848400), begin)
+	assert.Equal(t, int64(1614852000), end)
+}
+
+func TestModule_LoadHistory(t *testing.T) {
+	var begin, end string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		begin, end = r.URL.Query().Get("begin"), r.URL.Query().Get("end")
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`[[1614848400,100],[1614850200,200],[1614852000,300]]`))
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	m, _, _ := newTestModule(t, cfg)
+
+	got, err := m.LoadHistory(context.Background(), time.Unix(1614852000, 0), time.Hour)
+
+	require.NoError(t, err)
+	assert.Equal(t, "1614848400", begin)
+	assert.Equal(t, "1614852000", end)
+	require.Len(t, got, 1)
+	assertPoints(t, []Point{{1614848400, 100}, {1614850200, 200}, {1614852000, 300}}, got[0].Data)
+}
+
+func TestModule_LoadHistoryInvalidDuration(t *testing.T) {
+	m, _, _ := newTestModule(t, NewConfig())
+
+	_, err := m.LoadHistory(context.Background(), time.Now(), time.Millisecond)
+
+	assert.Error(t, err)
+}
+
+func TestModule_LoadHistoryDoesNotOverlapPolls(t *testing.T) {
+	var inflight, overlaps int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&inflight, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		defer atomic.AddInt32(&inflight, -1)
+		time.Sleep(5 * time.Millisecond)
+
+		end, err := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+		if err != nil {
+			end = time.Now().Unix()
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,500]]`, end/20*20)
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.MaxConcurrentRequests = 4
+	m, _, _ := newTestModule(t, cfg)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+
+			_, err := m.fetch(context.Background())
+			assert.NoError(t, err)
+		}()
+		go func(i int) {
+			defer wg.Done()
+
+			_, err := m.LoadHistory(context.Background(), time.Now().Add(-time.Duration(i)*time.Hour), time.Hour)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&overlaps))
+}
@@ -0,0 +1,70 @@
+package iotawatt
+
+import (
+	"encoding/json"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// formatInput formats the value of the input in its unit. Power,
+// apparent and reactive power are scaled to kilo units like the
+// current value, power factors are shown to two decimals and other
+// units to tenths with their symbol.
+func (m *Module) formatInput(in Input, v float64) string {
+	switch kind := in.kind(); {
+	case kind == "pf":
+		return strings.Replace(strconv.FormatFloat(v, 'f', 2, 64), ".", m.cfg.DecimalSeparator, 1)
+	case kind == "va" || kind == "var":
+		whole, tenth, unit, _ := m.splitPower(v)
+		sym := strings.ToUpper(kind)
+		if unit == "kW" {
+			sym = "k" + sym
+		}
+		return whole + m.cfg.DecimalSeparator + tenth + " " + sym
+	}
+
+	sym, ok := unitSymbols[strings.ToLower(in.Unit)]
+	if !ok || sym == "W" {
+		return m.formatPower(v)
+	}
+	tenths := roundTenths(v, m.cfg.Rounding)
+	var sign string
+	if tenths < 0 {
+		sign = "-"
+		tenths = -tenths
+	}
+	return sign + strconv.FormatInt(tenths/10, 10) + m.cfg.DecimalSeparator + strconv.FormatInt(tenths%10, 10) + " " + sym
+}
+
+// renderInputs renders the latest value of each input in its own
+// element, created on first render, showing a dash for a missing value.
+func (m *Module) renderInputs(raw [][]float64) error {
+	const js = "(function () { let c = document.querySelector('#%s .inputs'); " +
+		"let e = c.querySelector('.current-input-%s'); " +
+		"if (!e) { e = document.createElement('div'); e.className = 'current-input-%s'; c.appendChild(e); } " +
+		"e.innerHTML = '<span class=\"label\">' + %s + '</span> <span class=\"number\">%s</span>'; })()"
+
+	for i, in := range m.inputs {
+		val := "&ndash;"
+		if len(raw) > 0 {
+			if v := raw[len(raw)-1][i+1]; !math.IsNaN(v) {
+				val = m.formatInput(in, v)
+			}
+		}
+		label := in.Label
+		if label == "" {
+			label = in.Name
+		}
+		// The label is json encoded, as it may hold any character.
+		b, err := json.Marshal(html.EscapeString(label))
+		if err != nil {
+			return err
+		}
+		if _, err = m.eval(js, m.name, in.Name, in.Name, string(b), val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
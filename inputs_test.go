@@ -0,0 +1,104 @@
+package iotawatt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModule_RenderInputs(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Inputs = []Input{
+		{Name: "main"},
+		{Name: "line", Unit: "Volts", Label: "Line"},
+		{Name: "pf", Unit: "PF"},
+		{Name: "solar"},
+	}
+	m, ui, _ := newTestModule(t, cfg)
+	raw := [][]float64{{1614852000, 1000, 100, 0.5, 10}, {1614852020, 1500, 120.26, 0.954, nan}}
+
+	err := m.renderInputs(raw)
+
+	require.NoError(t, err)
+	scripts := ui.scripts()
+	require.Len(t, scripts, 4)
+	want := []struct {
+		name, label, value string
+	}{
+		{name: "main", label: `"main"`, value: "1.5 kW"},
+		{name: "line", label: `"Line"`, value: "120.2 V"},
+		{name: "pf", label: `"pf"`, value: "0.95"},
+		{name: "solar", label: `"solar"`, value: "&ndash;"},
+	}
+	for i, w := range want {
+		assert.Contains(t, scripts[i], "c.querySelector('.current-input-"+w.name+"')")
+		assert.Contains(t, scripts[i], "' + "+w.label+" + '")
+		assert.Contains(t, scripts[i], `<span class="number">`+w.value+`</span>`)
+	}
+}
+
+func TestModule_RenderInputsEncodesLabels(t *testing.T) {
+	tests := []struct {
+		name  string
+		label string
+		want  string
+	}{
+		{
+			name:  "quotes",
+			label: `Bob's "AC"`,
+			want:  `"Bob\u0026#39;s \u0026#34;AC\u0026#34;"`,
+		},
+		{
+			name:  "markup",
+			label: "<b>Pool</b>",
+			want:  `"\u0026lt;b\u0026gt;Pool\u0026lt;/b\u0026gt;"`,
+		},
+		{
+			name:  "newline and backslash",
+			label: "Spa\n\\",
+			want:  `"Spa\n\\"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Inputs = []Input{{Name: "main", Label: test.label}}
+			m, ui, _ := newTestModule(t, cfg)
+
+			err := m.renderInputs([][]float64{{1614852000, 50}})
+
+			require.NoError(t, err)
+			require.Len(t, ui.scripts(), 1)
+			assert.Contains(t, ui.scripts()[0], "' + "+test.want+" + '")
+		})
+	}
+}
+
+func TestModule_PollShowInputsEvalsEachInput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,50,null,30]]`, time.Now().Unix()/20*20)
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{{Name: "l1"}, {Name: "l2"}, {Name: "l3"}}
+	cfg.ShowInputs = true
+	m, ui, _ := newTestModule(t, cfg)
+
+	err := m.poll(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, ui.count("c.querySelector('.current-input-"))
+	for _, in := range []struct{ name, value string }{{"l1", "50.0 W"}, {"l2", "&ndash;"}, {"l3", "30.0 W"}} {
+		assert.Equal(t, 1, ui.count("c.querySelector('.current-input-"+in.name+"')"), in.name)
+		assert.True(t, ui.evaluated(`<span class="number">`+in.value+`</span>`), in.name)
+	}
+}
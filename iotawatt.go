@@ -2,35 +2,71 @@ package iotawatt
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/glasslabs/looking-glass/module/types"
+	"golang.org/x/time/rate"
 )
 
 const apiQueryPath = "query"
 
-// Config is the module configuration.
-type Config struct {
-	URL    string   `yaml:"url"`
-	Inputs []string `yaml:"inputs"`
+// longInterval is the interval above which the display updates so
+// rarely it may look broken.
+const longInterval = time.Hour
 
-	Interval time.Duration `yaml:"interval"`
+// newClient returns the http client for the module using the
+// configured connection settings.
+func newClient(cfg *Config) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.MaxIdleConns > 0 {
+		tr.MaxIdleConns = cfg.MaxIdleConns
+		tr.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	}
+	if cfg.IdleConnTimeout > 0 {
+		tr.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	tr.DisableKeepAlives = cfg.DisableKeepAlives
+	if cfg.ConnectTimeout > 0 {
+		tr.DialContext = (&net.Dialer{
+			Timeout:   cfg.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		tr.TLSHandshakeTimeout = cfg.ConnectTimeout
+	}
+	tr.ResponseHeaderTimeout = cfg.ResponseTimeout
+	if cfg.DisableHTTP2 {
+		// A non-nil empty map stops the transport upgrading to http/2.
+		tr.ForceAttemptHTTP2 = false
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		if tr.TLSClientConfig != nil {
+			tr.TLSClientConfig.NextProtos = nil
+		}
+	}
+
+	return &http.Client{Transport: tr}
 }
 
-// NewConfig creates a default configuration for the module.
-func NewConfig() *Config {
-	return &Config{
-		Interval: time.Minute,
+// WithHTTPClient sets the http client used to query the devices,
+// allowing several modules to share one connection pool. The
+// connection settings in the config are not applied to it.
+func WithHTTPClient(c *http.Client) Option {
+	return func(m *Module) {
+		m.client = c
+		m.sharedClient = true
 	}
 }
 
@@ -42,110 +78,678 @@ type Module struct {
 	ui   types.UI
 	log  types.Logger
 
-	baseURL *url.URL
-	qryVals url.Values
+	// interval is the effective poll interval, which may be raised
+	// to the device datalog interval.
+	interval time.Duration
+
+	client       *http.Client
+	sharedClient bool
+	limiter      *rate.Limiter
+	inflight     chan struct{}
+	timeout      *adaptiveTimeout
+	tracer       Tracer
+	devices      []*device
+	fetchMu      sync.Mutex
+	inputs       []Input
+	combined     []combined
+	derived      []derived
+	schedule     *schedule
+	hidden       map[int]bool
+	scales       []float64
+	totals       []int
+	allTotals    []int
+	visibility   *visibility
+	heat         *heatScale
+	loc          *time.Location
+
+	smoothed    float64
+	hasSmoothed bool
+
+	lastChart      time.Time
+	lastStats      time.Time
+	lastSnapshot   time.Time
+	noEvents       bool
+	alerts         []bool
+	polls          int
+	warnedNegative bool
+	chartMisses    int
+	chartOK        bool
+	uiFailed       bool
+	pushed         *pushed
+	points         []Point
+	validator      Validator
+
+	session *session
+	capture *capture
+	latest  latest
+	metrics *metrics
+	batch   *evalBatch
+	paused  int32
 
 	done chan struct{}
 }
 
 // New returns a running clock module.
-func New(_ context.Context, cfg *Config, info types.Info, ui types.UI) (io.Closer, error) {
-	qryValues := url.Values{
-		"format":     []string{"json"},
-		"resolution": []string{"low"},
-		"missing":    []string{"skip"},
-		"begin":      []string{"s-1h"},
-		"end":        []string{"s"},
-		"group":      []string{"auto"},
-	}
-	inputs := append([]string{"time.utc.unix"}, cfg.Inputs...)
-	qryValues.Set("select", "["+strings.Join(inputs, ",")+"]")
-
-	u, err := url.Parse(cfg.URL)
+func New(ctx context.Context, cfg *Config, info types.Info, ui types.UI, opts ...Option) (io.Closer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Interval > longInterval {
+		info.Log.Info("Interval is long, the display will rarely update", "module", "iotawatt", "id", info.Name, "op", "new", "interval", cfg.Interval.String())
+	}
+	for k := range cfg.QueryParams {
+		if contains(reservedQueryParams, k) {
+			info.Log.Info("Ignoring reserved query parameter", "module", "iotawatt", "id", info.Name, "op", "new", "param", k)
+		}
+	}
+
+	primary, err := newDevice(cfg, append([]string{cfg.URL}, cfg.FallbackURLs...), cfg.Inputs)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse url: %w", err)
+		return nil, fmt.Errorf("iotawatt: %w", err)
+	}
+	devices := []*device{primary}
+	for _, d := range cfg.Devices {
+		var dev *device
+		if dev, err = newDevice(cfg, append([]string{d.URL}, d.FallbackURLs...), d.Inputs); err != nil {
+			return nil, fmt.Errorf("iotawatt: %w", err)
+		}
+		devices = append(devices, dev)
 	}
 
-	m := &Module{
-		name:    info.Name,
-		path:    info.Path,
-		cfg:     cfg,
-		ui:      ui,
-		log:     info.Log,
-		baseURL: u,
-		qryVals: qryValues,
-		done:    make(chan struct{}),
+	inputs := cfg.allInputs()
+	totals, err := newTotals(inputs, cfg.ExcludeFromTotal)
+	if err != nil {
+		return nil, fmt.Errorf("iotawatt: %w", err)
 	}
 
-	if err = m.loadCSS("assets/style.css"); err != nil {
-		return nil, err
+	combined, hidden, err := newCombined(inputs, cfg.Combine)
+	if err != nil {
+		return nil, fmt.Errorf("iotawatt: %w", err)
+	}
+	if !cfg.HideCombined {
+		hidden = nil
+	}
+
+	var visPath string
+	if cfg.VisibilityFile != "" {
+		visPath = filepath.Join(info.Path, cfg.VisibilityFile)
+	}
+	vis, err := loadVisibility(visPath)
+	if err != nil {
+		// The visibility is only a display preference, so a corrupt
+		// file never blocks startup.
+		info.Log.Info("Ignoring IoTaWatt visibility, showing all inputs", "module", "iotawatt", "id", info.Name, "op", "new", "error", err.Error())
+		vis = newVisibility(visPath)
+	}
+
+	derived, err := newDerived(inputs, cfg.Derived)
+	if err != nil {
+		return nil, fmt.Errorf("iotawatt: %w", err)
+	}
+
+	sched, err := newSchedule(cfg.Schedule, cfg.location())
+	if err != nil {
+		return nil, fmt.Errorf("iotawatt: %w", err)
+	}
+
+	heat, err := newHeatScale(cfg.HeatColors)
+	if err != nil {
+		return nil, fmt.Errorf("iotawatt: %w", err)
 	}
-	if err = m.renderHTML("assets/index.html"); err != nil {
+
+	var limiter *rate.Limiter
+	if cfg.MaxRequestsPerMinute > 0 {
+		limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(cfg.MaxRequestsPerMinute)), 1)
+	}
+
+	m := &Module{
+		name:       info.Name,
+		path:       info.Path,
+		cfg:        cfg,
+		interval:   cfg.Interval,
+		ui:         ui,
+		log:        info.Log,
+		client:     newClient(cfg),
+		limiter:    limiter,
+		inflight:   make(chan struct{}, cfg.MaxConcurrentRequests),
+		tracer:     noopTracer{},
+		devices:    devices,
+		inputs:     inputs,
+		combined:   combined,
+		derived:    derived,
+		schedule:   sched,
+		hidden:     hidden,
+		scales:     newScales(inputs, cfg.Scale),
+		totals:     totals,
+		allTotals:  totals,
+		visibility: vis,
+		heat:       heat,
+		loc:        cfg.location(),
+		metrics:    newMetrics(),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if cfg.RequestTimeout > 0 {
+		maxTimeout := cfg.MaxRequestTimeout
+		if maxTimeout == 0 {
+			maxTimeout = cfg.Interval
+		}
+		m.timeout = newAdaptiveTimeout(cfg.RequestTimeout, maxTimeout, cfg.TimeoutEscalationAfter)
+	}
+	if cfg.CaptureFile != "" {
+		m.capture = newCapture(filepath.Join(m.path, cfg.CaptureFile), cfg.CaptureMaxSize, cfg.CaptureCompress, func(err error) {
+			m.log.Error("Could not capture response", m.logFields("new", "error", err)...)
+		})
+	}
+
+	if err = m.setup(ctx); err != nil {
+		// Nothing is running yet, only idle connections to clean up.
+		if !m.sharedClient {
+			m.client.CloseIdleConnections()
+		}
 		return nil, err
 	}
 
+	// The poll loop must be started last, so a failure above
+	// leaves no goroutine running.
+	if m.capture != nil {
+		go m.capture.run(m.done)
+	}
 	go m.run()
 
 	return m, nil
 }
 
-type series struct {
-	Data [][]float64 `json:"data"`
+// setup prepares the module state and loads the ui.
+func (m *Module) setup(ctx context.Context) error {
+	cfg := m.cfg
+
+	var err error
+	if cfg.SessionEnergy {
+		if m.session, err = loadSession(filepath.Join(m.path, cfg.SessionFile), cfg.SessionSaveInterval); err != nil {
+			return fmt.Errorf("iotawatt: %w", err)
+		}
+	}
+
+	if cfg.RequireConnection {
+		if err = m.checkConnection(ctx); err != nil {
+			return err
+		}
+	}
+
+	if cfg.AutoInterval {
+		if err = m.tuneInterval(ctx); err != nil {
+			m.log.Error("Could not read IoTaWatt datalog interval, using the configured interval", m.logFields("setup", "error", err)...)
+		}
+	}
+
+	if cfg.Legend && cfg.chart() {
+		if err = m.ui.Bind(m.legendFunction(), m.toggleLegend); err != nil {
+			return fmt.Errorf("iotawatt: could not bind legend: %w", err)
+		}
+	}
+
+	if cfg.DetectFirmware {
+		if err = m.detectFirmware(ctx); err != nil {
+			m.log.Error("Could not detect IoTaWatt firmware", m.logFields("setup", "error", err)...)
+		}
+	}
+
+	if cfg.CheckUI {
+		if err = m.checkUI(); err != nil {
+			return err
+		}
+	}
+	if err = m.renderUIWithRetry(ctx); err != nil {
+		return err
+	}
+	if cfg.SnapshotFile != "" {
+		m.restoreSnapshot()
+	}
+	return nil
 }
 
-func (m *Module) run() {
-	c := http.Client{}
+// renderUIWithRetry renders the ui, retrying the configured number
+// of times when the ui is not ready yet.
+func (m *Module) renderUIWithRetry(ctx context.Context) error {
+	err := m.renderUI()
+	for i := 0; err != nil && i < m.cfg.UIRetries; i++ {
+		m.log.Info("Could not render module, retrying", m.logFields("renderUIWithRetry", "delay", m.cfg.UIRetryDelay.String(), "error", err)...)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("iotawatt: startup aborted: %w", ctx.Err())
+		case <-time.After(m.cfg.UIRetryDelay):
+		}
+		err = m.renderUI()
+	}
+	return err
+}
 
-	ticker := time.NewTicker(m.cfg.Interval)
+// renderUI injects the module css, html and chart options.
+func (m *Module) renderUI() error {
+	// A new chart has none of the pushed series, and is checked
+	// again before it is updated.
+	m.pushed = nil
+	m.chartOK = false
+	if err := m.loadCSS("assets/style.css"); err != nil {
+		return err
+	}
+	tmpl := "assets/index.html"
+	switch {
+	case m.cfg.NumberOnly:
+		tmpl = "assets/number.html"
+	case m.cfg.DisplayMode == "gauge":
+		tmpl = "assets/gauge.html"
+	}
+	if err := m.renderHTML(tmpl); err != nil {
+		return err
+	}
+	switch {
+	case m.cfg.NumberOnly:
+	case m.cfg.DisplayMode == "gauge":
+		if _, err := m.ui.Eval("%s = {min: %s, max: %s}; %s()", m.jsName("iotaWattGaugeRange"),
+			strconv.FormatFloat(m.cfg.GaugeMin, 'f', -1, 64), strconv.FormatFloat(m.cfg.GaugeMax, 'f', -1, 64), m.jsName("reloadGauge")); err != nil {
+			return fmt.Errorf("iotawatt: could not load gauge range: %w", err)
+		}
+	default:
+		if err := m.renderChartOptions(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureUI injects the module again when the ui has been reloaded
+// and the module markup, along with the chart, is gone. The ui is only
+// checked after an update failed, saving a round trip on each poll.
+func (m *Module) ensureUI() {
+	if !m.uiFailed {
+		return
+	}
+	m.chartOK = false
+
+	res, err := m.ui.Eval(m.currentQuery()+" !== null", m.name)
+	if err == nil && res == true {
+		m.uiFailed = false
+		return
+	}
+
+	m.log.Info("UI was reloaded, rendering module again", m.logFields("ensureUI")...)
+	err = m.renderUI()
+	if err != nil {
+		m.log.Error("Could not render module", m.logFields("ensureUI", "error", err)...)
+	}
+	m.uiFailed = err != nil
+}
+
+func (m *Module) run() {
+	ticker := time.NewTicker(m.interval)
 	defer ticker.Stop()
 
+	// Poll straight away rather than a full interval after startup.
+	first := make(chan time.Time, 1)
+	first <- time.Now()
+
+	var heartbeat <-chan time.Time
+	if m.cfg.HeartbeatInterval > 0 {
+		hbTicker := time.NewTicker(m.cfg.HeartbeatInterval)
+		defer hbTicker.Stop()
+		heartbeat = hbTicker.C
+	}
+
+	lastSuccess := time.Now()
+	errLog := &logSampler{window: m.cfg.ErrorLogWindow}
+	conn := newConnection(m.cfg.DegradedAfter, m.cfg.DisconnectedAfter)
+	var (
+		cleared    bool
+		uiFailures int
+		hasError   bool
+	)
 	for {
 		select {
 		case <-m.done:
+			m.renderDisconnected()
 			return
+		case <-heartbeat:
+			uiFailures = m.checkHeartbeat(uiFailures)
+			continue
+		case <-first:
 		case <-ticker.C:
 		}
 
-		var raw [][]float64
-		if err := m.request(c, &raw); err != nil {
-			m.log.Error("Could not get current IoTaWatt data", "module", "iotawatt", "id", m.name, "error", err.Error())
+		if atomic.LoadInt32(&m.paused) == 1 {
+			continue
+		}
+		if m.cfg.PauseOnUILoss && uiFailures >= m.cfg.HeartbeatFailures {
 			continue
 		}
 
-		l := len(m.cfg.Inputs)
-		rl := len(raw)
-		var current float64
-		for i := 1; i <= l; i++ {
-			current += raw[rl-1][i]
+		reqID := newRequestID()
+		ctx, cancel := m.pollContext(reqID)
+		start := time.Now()
+		err := m.poll(ctx)
+		elapsed := time.Since(start)
+		if m.cfg.OverviewWindow > 0 && m.cfg.chart() {
+			if err := m.pollOverview(ctx); err != nil {
+				m.log.Error("Could not update IoTaWatt overview", m.logFields("pollOverview", "requestId", reqID, "error", err)...)
+			}
+		}
+		cancel()
+		m.metrics.addPoll(err)
+		state, changed := conn.record(err)
+		if changed {
+			m.log.Info("IoTaWatt connection state changed", m.logFields("poll", "state", state, "duration", elapsed.String())...)
+			m.metrics.setState(state)
+			if state == stateConnected && m.cfg.MaxBackoff > 0 {
+				ticker.Reset(m.interval)
+			}
+		}
+		// The state is rendered on each poll as a re-rendered ui
+		// loses the class.
+		if err := m.renderState(state); err != nil {
+			m.uiFailed = true
+			m.log.Error("Could not update connection state", m.logFields("renderState", "error", err)...)
+		}
+		if m.cfg.ErrorTooltip && (err != nil || hasError) {
+			if uiErr := m.renderError(err); uiErr != nil {
+				m.log.Error("Could not update error", m.logFields("renderError", "error", uiErr)...)
+			}
+			hasError = err != nil
 		}
+		if err == nil {
+			if n := errLog.reset(); n > 0 {
+				m.log.Info("Suppressed similar errors before recovering", m.logFields("poll", "count", n)...)
+			}
+			lastSuccess = time.Now()
+			cleared = false
+			continue
+		}
+		if ok, n := errLog.allow(err.Error(), time.Now()); ok {
+			if n > 0 {
+				m.log.Info("Suppressed similar errors", m.logFields("poll", "count", n, "window", m.cfg.ErrorLogWindow.String())...)
+			}
+			m.log.Error("Could not get current IoTaWatt data", m.logFields("poll", "requestId", reqID, "duration", elapsed.String(), "error", err)...)
+		}
+		if err := m.renderStale(); err != nil {
+			m.log.Error("Could not update current", m.logFields("renderStale", "error", err)...)
+		}
+
+		var tErr *throttledError
+		if errors.As(err, &tErr) {
+			delay := tErr.retryAfter
+			if delay > m.cfg.MaxRetryAfter {
+				delay = m.cfg.MaxRetryAfter
+			}
+			m.log.Info("IoTaWatt is throttling requests, delaying next poll", m.logFields("poll", "delay", delay.String())...)
 
-		series := make([]series, l)
-		for _, row := range raw {
-			if int(row[0])%20 != 0 {
-				continue
+			select {
+			case <-m.done:
+				m.renderDisconnected()
+				return
+			case <-time.After(delay):
 			}
-			for j := 1; j <= l; j++ {
-				series[j-1].Data = append(series[j-1].Data, []float64{row[0], row[j]})
+			ticker.Reset(m.interval)
+		}
+		if m.cfg.MaxBackoff > 0 && conn.state == stateDisconnected {
+			ticker.Reset(conn.backoff(m.interval, m.cfg.MaxBackoff))
+		}
+
+		if m.cfg.MaxDataAge > 0 && !cleared && time.Since(lastSuccess) > m.cfg.MaxDataAge {
+			if err := m.renderNoData(); err != nil {
+				m.log.Error("Could not clear stale data", m.logFields("renderNoData", "error", err)...)
 			}
+			cleared = true
+		}
+	}
+}
+
+// prepareRows scales and cleans the rows fetched for the window
+// [begin, end], returning them in time order without duplicate
+// timestamps.
+func (m *Module) prepareRows(raw [][]float64, begin, end time.Time) [][]float64 {
+	if skew := m.cfg.ClockSkew; skew > 0 {
+		min, max := float64(begin.Add(-skew).Unix()), float64(end.Add(skew).Unix())
+		var n int
+		if raw, n = dropImplausibleTimes(raw, min, max); n > 0 {
+			m.log.Info("Skipped rows with implausible timestamps, check the device clock", m.logFields("prepareRows", "count", n)...)
+		}
+	}
+	m.applyScales(raw)
+	if n := scrubInfinite(raw); n > 0 {
+		m.log.Info("Replaced infinite values with missing values", m.logFields("prepareRows", "count", n)...)
+	}
+	if n := m.scrubPowerFactors(raw); n > 0 {
+		m.log.Info("Replaced out of range power factors with missing values", m.logFields("prepareRows", "count", n)...)
+	}
+	m.applyNoiseFloors(raw)
+	// Some firmware returns the newest rows first.
+	sortRows(raw)
+	raw = mergeDuplicates(raw, m.cfg.Duplicates)
+	if m.cfg.MinCadence > 0 {
+		raw = bucketRows(raw, m.cfg.MinCadence.Seconds())
+	}
+	return raw
+}
+
+// poll fetches and renders the current data, returning
+// an error if the data could not be fetched.
+func (m *Module) poll(ctx context.Context) error {
+	raw, err := m.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	m.ensureUI()
+
+	m.startBatch()
+	defer func() {
+		if err := m.flushBatch(); err != nil {
+			m.log.Error("Could not update ui", m.logFields("poll", "error", err)...)
 		}
+	}()
+
+	now := time.Now()
+	raw = m.prepareRows(raw, now.Add(-m.cfg.span()), now)
 
-		if err := m.renderCurrent(current); err != nil {
-			m.log.Error("Could not update current", "module", "iotawatt", "id", m.name, "error", err.Error())
+	if m.cfg.ExcludeHidden {
+		// The totals are only taken on the poll goroutine.
+		m.totals = m.visibleTotals()
+	}
+
+	var current float64
+	total := m.current(raw)
+	if !math.IsNaN(total) {
+		current = total
+	}
+	if m.validator != nil {
+		if err = m.validator(m.newReading(raw, total)); err != nil {
+			m.log.Error("IoTaWatt data rejected, not updating display", m.logFields("poll", "requestId", requestID(ctx), "error", err)...)
+			return nil
 		}
+	}
 
+	// The current value is live, while the chart and stats only cover
+	// the scheduled hours.
+	charted := raw
+	if m.schedule != nil {
+		charted = m.schedule.filter(raw)
+	}
+
+	// The poll reuses its points, the series are copied to be kept.
+	series, times, points := m.buildSeriesInto(charted, m.points)
+	m.points = points
+	if m.cfg.Stacked && !m.warnedNegative && hasNegative(series) {
+		m.log.Info("Stacked series contain negative values, the stacked areas may be misleading", m.logFields("poll")...)
+		m.warnedNegative = true
+	}
+	m.latest.set(total, series)
+
+	switch {
+	case math.IsNaN(total):
+		err = m.renderStale()
+	case math.IsInf(current, 0):
+		m.log.Info("Current value is not finite", m.logFields("poll", "current", current)...)
+		err = m.renderCurrentPlaceholder()
+	default:
+		err = m.renderCurrent(m.smooth(current))
+	}
+	if err != nil {
+		m.log.Error("Could not update current", m.logFields("poll", "error", err)...)
+	}
+
+	if len(raw) > 0 && !math.IsInf(current, 0) {
+		m.metrics.addEnergy(raw[len(raw)-1][0], current, m.energyGap(raw))
+	}
+	if m.cfg.ShowTrend {
+		if err = m.renderTrend(m.trend(charted)); err != nil {
+			m.log.Error("Could not update trend", m.logFields("poll", "error", err)...)
+		}
+	}
+
+	if err = m.updateAlerts(raw); err != nil {
+		m.log.Error("Could not update alerts", m.logFields("poll", "error", err)...)
+	}
+
+	if len(m.derived) > 0 {
+		if err = m.renderDerived(raw); err != nil {
+			m.log.Error("Could not update derived", m.logFields("poll", "error", err)...)
+		}
+	}
+
+	if m.cfg.ShowInputs {
+		if err = m.renderInputs(raw); err != nil {
+			m.log.Error("Could not update inputs", m.logFields("poll", "error", err)...)
+		}
+	}
+
+	if m.cfg.ShowStats {
+		m.polls++
+		// The stats are always rendered during the warmup, so they
+		// are shown as soon as it ends.
+		if m.cfg.StatsInterval == 0 || m.polls <= m.cfg.WarmupPolls || time.Since(m.lastStats) >= m.cfg.StatsInterval {
+			if err = m.renderStats(charted); err != nil {
+				m.log.Error("Could not update stats", m.logFields("poll", "error", err)...)
+			}
+			m.lastStats = time.Now()
+		}
+	}
+
+	if m.session != nil && len(raw) > 0 && !math.IsInf(current, 0) {
+		wh, err := m.session.add(raw[len(raw)-1][0], current, m.energyGap(raw))
+		if err != nil {
+			m.log.Error("Could not save session", m.logFields("poll", "error", err)...)
+		}
+		if err = m.renderSession(wh); err != nil {
+			m.log.Error("Could not update session", m.logFields("poll", "error", err)...)
+		}
+	}
+
+	if m.cfg.SnapshotFile != "" {
+		m.persistSnapshot()
+	}
+
+	if m.cfg.NumberOnly {
+		return nil
+	}
+	if m.cfg.DisplayMode == "gauge" {
+		if !math.IsInf(current, 0) {
+			if _, err = m.eval("%s(%s)", m.jsName("updateGauge"), strconv.FormatFloat(current, 'f', -1, 64)); err != nil {
+				m.log.Error("Could not update gauge", m.logFields("poll", "error", err)...)
+			}
+		}
+		return nil
+	}
+	if m.cfg.ChartInterval > 0 && time.Since(m.lastChart) < m.cfg.ChartInterval {
+		return nil
+	}
+	m.lastChart = time.Now()
+
+	delta := m.cfg.DeltaUpdates && m.renderDelta(series)
+	if !delta {
 		b, err := json.Marshal(series)
 		if err != nil {
-			m.log.Error("could not encode data", "module", "iotawatt", "id", m.name, "error", err.Error())
-			continue
+			m.log.Error("could not encode data", m.logFields("poll", "error", err)...)
+			return nil
 		}
 
-		if _, err = m.ui.Eval("iotaWattSeries = %s", string(b)); err != nil {
-			m.log.Error("Could not update series", "module", "iotawatt", "id", m.name, "error", err.Error())
+		if _, err = m.eval("%s = %s", m.jsName(m.cfg.SeriesVariable), string(b)); err != nil {
+			m.log.Error("Could not update series", m.logFields("poll", "error", err)...)
+		}
+	}
+	if m.cfg.IncludeTimes {
+		if err = m.renderTimes(times); err != nil {
+			m.log.Error("Could not update times", m.logFields("poll", "error", err)...)
+		}
+	}
+	if !delta {
+		if !m.chartReady() {
+			// The chart is loaded with the series once the library is.
+			return nil
 		}
-		if _, err = m.ui.Eval("iotaWattChart.update({series: iotaWattSeries},true,true)"); err != nil {
-			m.log.Error("Could not update chart", "module", "iotawatt", "id", m.name, "error", err.Error())
+		if _, err = m.eval("%s.update({series: %s},true,true)", m.jsName(m.cfg.ChartVariable), m.jsName(m.cfg.SeriesVariable)); err != nil {
+			m.log.Error("Could not update chart", m.logFields("poll", "error", err)...)
 		}
+		m.pushed = newPushed(series)
 	}
+	if m.cfg.EventsEndpoint != "" && !m.noEvents && len(raw) > 0 {
+		m.updateEvents(ctx, raw[0][0], raw[len(raw)-1][0])
+	}
+	return nil
+}
+
+// smooth applies exponential smoothing to the current value.
+func (m *Module) smooth(current float64) float64 {
+	alpha := m.cfg.CurrentSmoothing
+	if alpha == 0 {
+		return current
+	}
+
+	if !m.hasSmoothed {
+		m.smoothed = current
+		m.hasSmoothed = true
+		return current
+	}
+	m.smoothed = alpha*current + (1-alpha)*m.smoothed
+	return m.smoothed
+}
+
+// checkHeartbeat checks the ui is still alive, returning the
+// number of consecutive failed checks.
+func (m *Module) checkHeartbeat(failures int) int {
+	res, err := m.ui.Eval("typeof document !== 'undefined'")
+	if err == nil && res == true {
+		if failures >= m.cfg.HeartbeatFailures {
+			m.log.Info("UI recovered", m.logFields("checkHeartbeat")...)
+		}
+		return 0
+	}
+
+	failures++
+	if failures == m.cfg.HeartbeatFailures {
+		msg := "unexpected result"
+		if err != nil {
+			msg = err.Error()
+		}
+		m.log.Error("UI is not responding", m.logFields("checkHeartbeat", "failures", failures, "error", msg)...)
+	}
+	return failures
+}
+
+// checkUI verifies the ui evaluates scripts by evaluating a sentinel.
+func (m *Module) checkUI() error {
+	const sentinel = "iotawatt-ok"
+
+	res, err := m.ui.Eval("%q", sentinel)
+	if err != nil {
+		return fmt.Errorf("iotawatt: ui is not evaluating scripts: %w", err)
+	}
+	if res != sentinel {
+		return fmt.Errorf("iotawatt: ui returned %v evaluating a sentinel, expected %q", res, sentinel)
+	}
+	return nil
 }
 
 func (m *Module) loadCSS(path string) error {
@@ -161,7 +765,7 @@ func (m *Module) renderHTML(path string) error {
 	if err != nil {
 		return fmt.Errorf("iotawatt: could not read html: %w", err)
 	}
-	if err = m.ui.LoadHTML(string(html)); err != nil {
+	if err = m.ui.LoadHTML(strings.ReplaceAll(string(html), "{{id}}", instanceID(m.name))); err != nil {
 		return fmt.Errorf("iotawatt: could not load html: %w", err)
 	}
 
@@ -169,62 +773,279 @@ func (m *Module) renderHTML(path string) error {
 	return err
 }
 
-func (m *Module) renderCurrent(watt float64) error {
-	const docSelector = "document.querySelector('#%s .current')"
+// jsName returns the javascript global name for the module instance,
+// the name suffixed with the instance identifier the html is rendered
+// with, so several modules on one page do not clobber each other.
+func (m *Module) jsName(name string) string {
+	return name + "_" + instanceID(m.name)
+}
+
+// legendFunction returns the name of the function bound for the
+// legend to set the visibility of an input.
+func (m *Module) legendFunction() string {
+	return m.jsName(m.cfg.ChartVariable + "SetVisible")
+}
+
+// instanceID returns the module name with every character not valid
+// in a javascript identifier replaced by an underscore.
+func instanceID(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
 
-	unit := "W"
-	removeClass := "kW"
+// splitPower splits the power into its rounded whole and tenth
+// digits, in watts or kilowatts above 100W, with the unit and its
+// configured suffix.
+func (m *Module) splitPower(watt float64) (whole, tenth, unit, suffix string) {
+	unit = "W"
+	suffix = m.cfg.WattSuffix
 	if watt > 100 {
 		unit = "kW"
-		removeClass = "W"
+		suffix = m.cfg.KilowattSuffix
 		watt /= 1000
 	}
 
-	w := int(watt)
-	d := int(watt*10) - (w * 10)
+	tenths := roundTenths(watt, m.cfg.Rounding)
+	var sign string
+	if tenths < 0 {
+		sign = "-"
+		tenths = -tenths
+	}
+	return sign + strconv.FormatInt(tenths/10, 10), strconv.FormatInt(tenths%10, 10), unit, suffix
+}
+
+// unitClasses are the classes of the current value for each unit.
+const unitClasses = "'W', 'kW', 'BTU', 'cost'"
 
-	ws := strconv.Itoa(w)
-	ds := strconv.Itoa(d)
-	if _, err := m.ui.Eval(docSelector+".innerHTML = '%s<sel>.%s %s</sel>'", m.name, ws, ds, unit); err != nil {
+// btuPerWatt is the number of BTU/h in a watt.
+const btuPerWatt = 3.412142
+
+// splitDisplay splits the power into its whole and fractional
+// digits in the configured display unit, with the unit class and
+// suffix.
+func (m *Module) splitDisplay(watt float64) (whole, frac, unit, suffix string) {
+	switch m.cfg.DisplayUnit {
+	case "btu":
+		tenths := roundTenths(watt*btuPerWatt, m.cfg.Rounding)
+		var sign string
+		if tenths < 0 {
+			sign = "-"
+			tenths = -tenths
+		}
+		return sign + strconv.FormatInt(tenths/10, 10), strconv.FormatInt(tenths%10, 10), "BTU", "BTU/h"
+	case "cost":
+		cents := int64(math.Round(watt / 1000 * m.cfg.Tariff * 100))
+		var sign string
+		if cents < 0 {
+			sign = "-"
+			cents = -cents
+		}
+		return sign + m.cfg.CurrencySymbol + strconv.FormatInt(cents/100, 10), fmt.Sprintf("%02d", cents%100), "cost", "/h"
+	default:
+		return m.splitPower(watt)
+	}
+}
+
+// formatPower formats the power as text, e.g. "1.2 kW".
+func (m *Module) formatPower(watt float64) string {
+	whole, tenth, _, suffix := m.splitPower(watt)
+	s := whole + m.cfg.DecimalSeparator + tenth
+	if suffix != "" {
+		s += " " + suffix
+	}
+	return s
+}
+
+func (m *Module) renderCurrent(watt float64) error {
+	docSelector := m.currentQuery()
+
+	if m.heat != nil {
+		if _, err := m.eval(docSelector+".style.color = '%s'", m.name, m.heat.color(watt)); err != nil {
+			return err
+		}
+	}
+
+	ws, ds, unit, suffix := m.splitDisplay(watt)
+	if suffix != "" {
+		suffix = " " + suffix
+	}
+
+	if _, err := m.eval(docSelector+".innerHTML = '%s<sel>%s%s%s</sel>'", m.name, ws, m.cfg.DecimalSeparator, ds, suffix); err != nil {
 		return err
 	}
-	_, err := m.ui.Eval(docSelector+".classList.remove('%s')", m.name, removeClass)
+	_, err := m.eval(docSelector+".classList.remove(%s, 'stale')", m.name, unitClasses)
 	if err != nil {
 		return err
 	}
-	_, err = m.ui.Eval(docSelector+".classList.add('%s')", m.name, unit)
+	_, err = m.eval(docSelector+".classList.add('%s')", m.name, unit)
 	return err
 }
 
-func (m *Module) request(c http.Client, v interface{}) error {
-	u, err := m.baseURL.Parse(apiQueryPath)
-	if err != nil {
-		return fmt.Errorf("could not parse url: %w", err)
+// renderDisconnected dims the display when the module stops.
+func (m *Module) renderDisconnected() {
+	// The ui may already be torn down on shutdown.
+	_, _ = m.ui.Eval("document.querySelector('#%s .iotawatt').classList.add('disconnected')", m.name)
+}
+
+// roundTenths rounds the value to tenths using the given rounding mode,
+// returning the number of tenths.
+func roundTenths(v float64, mode string) int64 {
+	// Remove floating point noise so e.g. 0.15 is not treated as 0.1499.
+	t := math.Round(v*10*1e6) / 1e6
+
+	switch mode {
+	case "half-up":
+		t = math.Round(t)
+	case "half-even":
+		t = math.RoundToEven(t)
+	default:
+		t = math.Trunc(t)
 	}
-	u.RawQuery = m.qryVals.Encode()
+	return int64(t)
+}
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
-	if err != nil {
-		return fmt.Errorf("could create request: %w", err)
+func (m *Module) renderCurrentPlaceholder() error {
+	docSelector := m.currentQuery()
+
+	if _, err := m.eval(docSelector+".classList.remove(%s)", m.name, unitClasses); err != nil {
+		return err
+	}
+	_, err := m.eval(docSelector+".innerHTML = '-'", m.name)
+	return err
+}
+
+// renderStale renders the current value when there is no fresh data,
+// either marking the last value as stale or showing the configured
+// placeholder.
+func (m *Module) renderStale() error {
+	docSelector := m.currentQuery()
+
+	if m.cfg.NoDataDisplay == "" {
+		_, err := m.eval(docSelector+".classList.add('stale')", m.name)
+		return err
+	}
+
+	if _, err := m.eval(docSelector+".classList.remove(%s)", m.name, unitClasses); err != nil {
+		return err
+	}
+	_, err := m.eval(docSelector+".innerHTML = '%s'", m.name, m.cfg.NoDataDisplay)
+	return err
+}
+
+func (m *Module) renderNoData() error {
+	docSelector := m.currentQuery()
+
+	if m.cfg.chart() {
+		m.pushed = nil
+		if _, err := m.eval("%s = []", m.jsName(m.cfg.SeriesVariable)); err != nil {
+			return err
+		}
+		if _, err := m.eval("%s.update({series: %s},true,true)", m.jsName(m.cfg.ChartVariable), m.jsName(m.cfg.SeriesVariable)); err != nil {
+			return err
+		}
 	}
-	resp, err := c.Do(req)
+	if _, err := m.eval(docSelector+".classList.remove(%s)", m.name, unitClasses); err != nil {
+		return err
+	}
+	_, err := m.eval(docSelector+".innerHTML = '<sel>No recent data</sel>'", m.name)
+	return err
+}
+
+// trendArrows are the arrows displayed for each trend.
+var trendArrows = map[string]string{
+	"up":   "&uarr;",
+	"down": "&darr;",
+	"flat": "&rarr;",
+}
+
+func (m *Module) renderTrend(trend string) error {
+	const docSelector = "document.querySelector('#%s .trend')"
+
+	if _, err := m.eval(docSelector+".className = 'trend %s'", m.name, trend); err != nil {
+		return err
+	}
+	_, err := m.eval(docSelector+".innerHTML = '%s'", m.name, trendArrows[trend])
+	return err
+}
+
+// renderStats renders the minimum, average and maximum total over the
+// window, or the warmup text until enough polls have been made.
+func (m *Module) renderStats(raw [][]float64) error {
+	const docSelector = "document.querySelector('#%s .stats')"
+
+	if m.polls < m.cfg.WarmupPolls {
+		_, err := m.eval(docSelector+".innerHTML = '%s'", m.name, m.cfg.WarmupText)
+		return err
+	}
+
+	lo, avg, hi := m.stats(raw)
+	if math.IsNaN(avg) || math.IsInf(lo, 0) || math.IsInf(hi, 0) {
+		_, err := m.eval(docSelector+".innerHTML = ''", m.name)
+		return err
+	}
+	_, err := m.eval(docSelector+".innerHTML = 'min %s &middot; avg %s &middot; max %s'", m.name,
+		m.formatPower(lo), m.formatPower(avg), m.formatPower(hi))
+	return err
+}
+
+func (m *Module) renderTimes(times []float64) error {
+	if times == nil {
+		times = []float64{}
+	}
+	b, err := json.Marshal(times)
 	if err != nil {
-		return fmt.Errorf("could not parse url: %w", err)
+		return fmt.Errorf("could not encode times: %w", err)
+	}
+	_, err = m.eval("%s = %s", m.jsName("iotaWattTimes"), string(b))
+	return err
+}
+
+// Pause pauses polling the devices until resumed.
+func (m *Module) Pause() {
+	if !atomic.CompareAndSwapInt32(&m.paused, 0, 1) {
+		return
 	}
-	defer func() { _ = resp.Body.Close() }()
+	if _, err := m.ui.Eval("document.querySelector('#%s .iotawatt').classList.add('paused')", m.name); err != nil {
+		m.log.Error("Could not update paused state", m.logFields("pause", "error", err)...)
+	}
+}
 
-	if resp.StatusCode != 200 {
-		return errors.New("expected status code")
+// Resume resumes polling the devices.
+func (m *Module) Resume() {
+	if !atomic.CompareAndSwapInt32(&m.paused, 1, 0) {
+		return
+	}
+	if _, err := m.ui.Eval("document.querySelector('#%s .iotawatt').classList.remove('paused')", m.name); err != nil {
+		m.log.Error("Could not update paused state", m.logFields("resume", "error", err)...)
 	}
+}
 
-	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
-		return fmt.Errorf("could not parse data: %w", err)
+// closed returns if the module has been closed.
+func (m *Module) closed() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
 	}
-	return nil
 }
 
 // Close stops and closes the module.
 func (m *Module) Close() error {
 	close(m.done)
+	// A poll in progress is cancelled by done, leaving idle connections.
+	if !m.sharedClient {
+		m.client.CloseIdleConnections()
+	}
+
+	if m.session != nil {
+		if err := m.session.flush(); err != nil {
+			return fmt.Errorf("iotawatt: %w", err)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,719 @@
+package iotawatt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glasslabs/looking-glass/module/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	"golang.org/x/time/rate"
+)
+
+var nan = math.NaN()
+
+func assertRows(t *testing.T, want, got [][]float64) {
+	t.Helper()
+
+	require.Len(t, got, len(want))
+	for i := range want {
+		require.Len(t, got[i], len(want[i]), "row %d", i)
+		for j := range want[i] {
+			assertFloat(t, want[i][j], got[i][j], "row %d column %d", i, j)
+		}
+	}
+}
+
+func assertFloat(t *testing.T, want, got float64, msgAndArgs ...interface{}) {
+	t.Helper()
+
+	if math.IsNaN(want) {
+		assert.True(t, math.IsNaN(got), msgAndArgs...)
+		return
+	}
+	assert.InDelta(t, want, got, 1e-9, msgAndArgs...)
+}
+
+// newTestModule returns a module built from the config like New, without
+// rendering the ui or starting the poll loop.
+func newTestModule(t *testing.T, cfg *Config) (*Module, *testUI, *testLogger) {
+	t.Helper()
+
+	if cfg.URL == "" {
+		cfg.URL = "http://iotawatt.local"
+	}
+	if len(cfg.Inputs) == 0 {
+		cfg.Inputs = []Input{{Name: "main"}}
+	}
+	require.NoError(t, cfg.Validate())
+
+	devices := make([]*device, 0, 1+len(cfg.Devices))
+	d, err := newDevice(cfg, append([]string{cfg.URL}, cfg.FallbackURLs...), cfg.Inputs)
+	require.NoError(t, err)
+	devices = append(devices, d)
+	for _, dev := range cfg.Devices {
+		d, err = newDevice(cfg, append([]string{dev.URL}, dev.FallbackURLs...), dev.Inputs)
+		require.NoError(t, err)
+		devices = append(devices, d)
+	}
+
+	inputs := cfg.allInputs()
+	totals, err := newTotals(inputs, cfg.ExcludeFromTotal)
+	require.NoError(t, err)
+	combined, hidden, err := newCombined(inputs, cfg.Combine)
+	require.NoError(t, err)
+	if !cfg.HideCombined {
+		hidden = nil
+	}
+	derived, err := newDerived(inputs, cfg.Derived)
+	require.NoError(t, err)
+	sched, err := newSchedule(cfg.Schedule, cfg.location())
+	require.NoError(t, err)
+	heat, err := newHeatScale(cfg.HeatColors)
+	require.NoError(t, err)
+
+	var limiter *rate.Limiter
+	if cfg.MaxRequestsPerMinute > 0 {
+		limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(cfg.MaxRequestsPerMinute)), 1)
+	}
+
+	ui := &testUI{}
+	log := &testLogger{}
+	m := &Module{
+		name:       "test",
+		path:       t.TempDir(),
+		cfg:        cfg,
+		interval:   cfg.Interval,
+		ui:         ui,
+		log:        log,
+		client:     newClient(cfg),
+		limiter:    limiter,
+		inflight:   make(chan struct{}, cfg.MaxConcurrentRequests),
+		tracer:     noopTracer{},
+		devices:    devices,
+		inputs:     inputs,
+		combined:   combined,
+		derived:    derived,
+		schedule:   sched,
+		hidden:     hidden,
+		scales:     newScales(inputs, cfg.Scale),
+		totals:     totals,
+		allTotals:  totals,
+		visibility: newVisibility(""),
+		heat:       heat,
+		loc:        cfg.location(),
+		metrics:    newMetrics(),
+		done:       make(chan struct{}),
+	}
+	t.Cleanup(func() {
+		if !m.closed() {
+			close(m.done)
+		}
+		m.client.CloseIdleConnections()
+	})
+	return m, ui, log
+}
+
+// testUI is a ui recording the evaluated scripts.
+type testUI struct {
+	htmlErr error
+
+	mu    sync.Mutex
+	html  string
+	evals []string
+}
+
+func (u *testUI) LoadCSS(string) error { return nil }
+
+func (u *testUI) LoadHTML(html string) error {
+	u.mu.Lock()
+	u.html = html
+	u.mu.Unlock()
+
+	return u.htmlErr
+}
+
+func (u *testUI) Bind(string, interface{}) error { return nil }
+
+func (u *testUI) Eval(cmd string, args ...interface{}) (interface{}, error) {
+	cmd = fmt.Sprintf(cmd, args...)
+
+	u.mu.Lock()
+	u.evals = append(u.evals, cmd)
+	u.mu.Unlock()
+
+	if strings.Contains(cmd, "typeof Highcharts") {
+		return "ready", nil
+	}
+	return true, nil
+}
+
+// scripts returns the evaluated scripts.
+func (u *testUI) scripts() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return append([]string(nil), u.evals...)
+}
+
+// evaluated returns if a script containing s was evaluated.
+func (u *testUI) evaluated(s string) bool {
+	for _, cmd := range u.scripts() {
+		if strings.Contains(cmd, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// loaded returns the last loaded html.
+func (u *testUI) loaded() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.html
+}
+
+// count returns the number of evaluated scripts containing s.
+func (u *testUI) count(s string) int {
+	var n int
+	for _, cmd := range u.scripts() {
+		if strings.Contains(cmd, s) {
+			n++
+		}
+	}
+	return n
+}
+
+// testLogger is a logger recording the logged messages.
+type testLogger struct {
+	mu   sync.Mutex
+	info []string
+	errs []string
+}
+
+func (l *testLogger) Info(msg string, _ ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.info = append(l.info, msg)
+}
+
+func (l *testLogger) Error(msg string, _ ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.errs = append(l.errs, msg)
+}
+
+// infos returns the logged info messages.
+func (l *testLogger) infos() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]string(nil), l.info...)
+}
+
+// errors returns the logged error messages.
+func (l *testLogger) errors() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]string(nil), l.errs...)
+}
+
+func TestModule_CloseRendersDisconnected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,500]]`, time.Now().Unix()/20*20)
+	}))
+	defer srv.Close()
+
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{{Name: "main"}}
+	ui := &testUI{}
+	mod, err := New(context.Background(), cfg, types.Info{Name: "test", Path: ".", Log: &testLogger{}}, ui)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return ui.evaluated("0<sel>.5 kW")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	err = mod.Close()
+
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return ui.evaluated("document.querySelector('#test .iotawatt').classList.add('disconnected')")
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestModule_MaxDataAgeClearsStaleData(t *testing.T) {
+	var failing int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,500]]`, time.Now().Unix()/20*20)
+	}))
+	defer srv.Close()
+
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{{Name: "main"}}
+	cfg.Interval = 20 * time.Millisecond
+	cfg.MaxDataAge = 200 * time.Millisecond
+	ui := &testUI{}
+	mod, err := New(context.Background(), cfg, types.Info{Name: "test", Path: ".", Log: &testLogger{}}, ui)
+	require.NoError(t, err)
+	defer func() { _ = mod.Close() }()
+	require.Eventually(t, func() bool {
+		return ui.evaluated("0<sel>.5 kW")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	atomic.StoreInt32(&failing, 1)
+	failed := time.Now()
+
+	require.Eventually(t, func() bool {
+		return ui.evaluated("No recent data")
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, int64(time.Since(failed)), int64(150*time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, ui.count("No recent data"))
+}
+
+func TestModule_RetryAfterDelaysNextPoll(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		n := len(times)
+		mu.Unlock()
+
+		if n == 1 {
+			rw.Header().Set("Retry-After", "1")
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,500]]`, time.Now().Unix()/20*20)
+	}))
+	defer srv.Close()
+
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{{Name: "main"}}
+	cfg.Interval = 20 * time.Millisecond
+	log := &testLogger{}
+	mod, err := New(context.Background(), cfg, types.Info{Name: "test", Path: ".", Log: log}, &testUI{})
+	require.NoError(t, err)
+	defer func() { _ = mod.Close() }()
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(times) >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.GreaterOrEqual(t, int64(times[1].Sub(times[0])), int64(time.Second))
+	assert.Contains(t, log.infos(), "IoTaWatt is throttling requests, delaying next poll")
+}
+
+func TestRoundTenths(t *testing.T) {
+	tests := []struct {
+		mode string
+		v    float64
+		want int64
+	}{
+		{mode: "truncate", v: 0.05, want: 0},
+		{mode: "truncate", v: 0.15, want: 1},
+		{mode: "truncate", v: 0.25, want: 2},
+		{mode: "truncate", v: -1.29, want: -12},
+		{mode: "half-up", v: 0.05, want: 1},
+		{mode: "half-up", v: 0.15, want: 2},
+		{mode: "half-up", v: 0.25, want: 3},
+		{mode: "half-up", v: -0.15, want: -2},
+		{mode: "half-even", v: 0.05, want: 0},
+		{mode: "half-even", v: 0.15, want: 2},
+		{mode: "half-even", v: 0.25, want: 2},
+		{mode: "half-even", v: 0.35, want: 4},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %v", test.mode, test.v), func(t *testing.T) {
+			got := roundTenths(test.v, test.mode)
+
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestModule_RenderUINamespacesInstances(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     func(cfg *Config)
+		globals []string
+	}{
+		{
+			name:    "chart",
+			cfg:     func(*Config) {},
+			globals: []string{"iotaWattSeries", "iotaWattOptions", "iotaWattChart", "reloadChart", "loadChart", "appendChartData", "iotawattChart"},
+		},
+		{
+			name:    "gauge",
+			cfg:     func(cfg *Config) { cfg.DisplayMode = "gauge" },
+			globals: []string{"iotaWattGaugeRange", "iotaWattGauge", "updateGauge", "reloadGauge", "iotawattGauge"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			html := map[string]string{}
+			for _, name := range []string{"power", "solar panel"} {
+				cfg := NewConfig()
+				test.cfg(cfg)
+				m, ui, _ := newTestModule(t, cfg)
+				m.name = name
+				m.path = "."
+
+				err := m.renderUI()
+
+				require.NoError(t, err)
+				html[name] = ui.loaded()
+			}
+
+			for _, global := range test.globals {
+				assert.Contains(t, html["power"], global+"_power")
+				assert.Contains(t, html["solar panel"], global+"_solar_panel")
+				assert.NotContains(t, html["power"], global+"_solar_panel")
+			}
+			assert.NotContains(t, html["power"], "{{id}}")
+		})
+	}
+}
+
+func TestModule_EvalsUseInstanceNames(t *testing.T) {
+	cfg := NewConfig()
+	cfg.ChartVariable = "chart"
+	cfg.SeriesVariable = "series"
+	cfg.ReloadFunction = "reload"
+	cfg.Legend = true
+	m, ui, _ := newTestModule(t, cfg)
+	m.name = "solar-1"
+
+	err := m.renderChartOptions()
+	require.NoError(t, err)
+	err = m.renderNoData()
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{`iotaWattOptions_solar_1 = {"legend":"chartSetVisible_solar_1"}; reload_solar_1()`}, ui.scripts()[:1])
+	assert.True(t, ui.evaluated("series_solar_1 = []"))
+	assert.True(t, ui.evaluated("chart_solar_1.update({series: series_solar_1},true,true)"))
+}
+
+func TestModule_PauseAndResume(t *testing.T) {
+	m, ui, _ := newTestModule(t, NewConfig())
+
+	m.Pause()
+	m.Pause()
+
+	assert.Equal(t, 1, ui.count("classList.add('paused')"))
+
+	m.Resume()
+	m.Resume()
+
+	assert.Equal(t, 1, ui.count("classList.remove('paused')"))
+}
+
+func TestModule_PauseStopsPolling(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,500]]`, time.Now().Unix()/20*20)
+	}))
+	defer srv.Close()
+
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{{Name: "main"}}
+	cfg.Interval = 20 * time.Millisecond
+	ui := &testUI{}
+	c, err := New(context.Background(), cfg, types.Info{Name: "test", Path: ".", Log: &testLogger{}}, ui)
+	require.NoError(t, err)
+	defer func() { _ = c.Close() }()
+	mod := c.(*Module)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&hits) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mod.Pause()
+	// Let a poll in progress finish.
+	time.Sleep(50 * time.Millisecond)
+	paused := atomic.LoadInt32(&hits)
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, paused, atomic.LoadInt32(&hits))
+
+	mod.Resume()
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&hits) > paused
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNew_RenderFailureLeavesNoGoroutine(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,500]]`, time.Now().Unix()/20*20)
+	}))
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{{Name: "main"}}
+	cfg.RequireConnection = true
+	cfg.UIRetries = 0
+	ui := &testUI{htmlErr: errors.New("test")}
+
+	_, err := New(context.Background(), cfg, types.Info{Name: "test", Path: ".", Log: &testLogger{}}, ui)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "could not load html")
+	srv.Close()
+	goleak.VerifyNone(t)
+}
+
+func TestNew_CloseStopsPolling(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,500]]`, time.Now().Unix()/20*20)
+	}))
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{{Name: "main"}}
+	cfg.Interval = 100 * time.Millisecond
+	ui := &testUI{}
+	mod, err := New(context.Background(), cfg, types.Info{Name: "test", Path: ".", Log: &testLogger{}}, ui)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return ui.evaluated("0<sel>.5 kW")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	err = mod.Close()
+
+	require.NoError(t, err)
+	srv.Close()
+	goleak.VerifyNone(t)
+}
+
+func TestModule_CloseClosesIdleConnections(t *testing.T) {
+	tests := []struct {
+		name       string
+		shared     bool
+		wantClosed bool
+	}{
+		{
+			name:       "own client",
+			wantClosed: true,
+		},
+		{
+			name:   "shared client",
+			shared: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var closed int32
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				rw.Header().Set("Content-Type", "application/json")
+				_, _ = fmt.Fprintf(rw, `[[%d,500]]`, time.Now().Unix()/20*20)
+			}))
+			srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateClosed {
+					atomic.StoreInt32(&closed, 1)
+				}
+			}
+			srv.Start()
+			defer srv.Close()
+			cfg := NewConfig()
+			cfg.URL = srv.URL
+			m, _, _ := newTestModule(t, cfg)
+			m.sharedClient = test.shared
+			_, err := m.request(context.Background(), m.devices[0].baseURLs[0], nil)
+			require.NoError(t, err)
+
+			err = m.Close()
+
+			require.NoError(t, err)
+			if test.wantClosed {
+				assert.Eventually(t, func() bool {
+					return atomic.LoadInt32(&closed) == 1
+				}, time.Second, 10*time.Millisecond)
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+			assert.Equal(t, int32(0), atomic.LoadInt32(&closed))
+		})
+	}
+}
+
+func TestModule_PollBuffersAreBounded(t *testing.T) {
+	var polls int64
+	start := time.Now().Unix()/20*20 - 20*1000
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// Each poll returns a window of 10 rows moving forward in time.
+		end := start + 20*atomic.AddInt64(&polls, 1)
+		rows := make([]string, 0, 10)
+		for ts := end - 180; ts <= end; ts += 20 {
+			rows = append(rows, fmt.Sprintf("[%d,500,%d]", ts, ts%7))
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, "[%s]", strings.Join(rows, ","))
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{{Name: "main", Alert: 400}, {Name: "solar"}}
+	cfg.BatchEvals = true
+	cfg.CaptureFile = "capture.log"
+	m, _, _ := newTestModule(t, cfg)
+	m.capture = newCapture(filepath.Join(m.path, cfg.CaptureFile), cfg.CaptureMaxSize, false, func(error) {})
+	m.session, _ = loadSession(filepath.Join(m.path, "session.json"), time.Hour)
+
+	for i := 0; i < 1000; i++ {
+		err := m.poll(context.Background())
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, m.alerts, 2)
+	assert.Nil(t, m.batch)
+	assert.Len(t, m.capture.entries, captureBuffer)
+	require.NotNil(t, m.pushed)
+	assert.Len(t, m.pushed.last, 2)
+	snap := m.latest.get()
+	require.NotNil(t, snap)
+	for _, s := range snap.Series {
+		assert.LessOrEqual(t, len(s.Data), 10)
+	}
+}
+
+func TestModule_RequestIDPerPoll(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		ids []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids = append(ids, r.Header.Get("X-Request-ID"))
+		n := len(ids)
+		mu.Unlock()
+
+		rw.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			// The first request is cut short, so it is retried.
+			rw.Header().Set("Content-Length", "100")
+			_, _ = rw.Write([]byte(`[[1614852000,1`))
+			return
+		}
+		_, _ = fmt.Fprintf(rw, `[[%d,500]]`, time.Now().Unix()/20*20)
+	}))
+	defer srv.Close()
+
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{{Name: "main"}}
+	cfg.Interval = 20 * time.Millisecond
+	mod, err := New(context.Background(), cfg, types.Info{Name: "test", Path: ".", Log: &testLogger{}}, &testUI{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ids) >= 5
+	}, 5*time.Second, 10*time.Millisecond)
+	err = mod.Close()
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, ids[0])
+	assert.Equal(t, ids[0], ids[1], "a retry keeps the poll request id")
+	seen := map[string]bool{}
+	for _, id := range ids[1:] {
+		assert.False(t, seen[id], "request id %q is reused", id)
+		seen[id] = true
+	}
+}
+
+func TestModule_PollRendersScientificNotation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,1e-05,2e-05]]`, time.Now().Unix()/20*20)
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{{Name: "l1"}, {Name: "l2"}}
+	m, ui, _ := newTestModule(t, cfg)
+
+	err := m.poll(context.Background())
+
+	require.NoError(t, err)
+	for _, script := range ui.scripts() {
+		assert.NotContains(t, script, "e-05")
+	}
+	assert.True(t, ui.evaluated("0<sel>.0 W"))
+}
+
+func TestModule_PrepareRowsSkipsImplausibleTimes(t *testing.T) {
+	tests := []struct {
+		name      string
+		clockSkew time.Duration
+		want      [][]float64
+		wantLog   []string
+	}{
+		{
+			name:      "clock skew",
+			clockSkew: 24 * time.Hour,
+			want:      [][]float64{{1614852000, 2}},
+			wantLog:   []string{"Skipped rows with implausible timestamps, check the device clock"},
+		},
+		{
+			name: "without clock skew",
+			want: [][]float64{{0, 1}, {1614852000, 2}, {1614852000 + 7*86400, 3}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.ClockSkew = test.clockSkew
+			m, _, log := newTestModule(t, cfg)
+			end := time.Unix(1614852000, 0)
+			// An epoch-zero row from an unset clock and a row a week ahead.
+			raw := [][]float64{{0, 1}, {1614852000, 2}, {1614852000 + 7*86400, 3}}
+
+			got := m.prepareRows(raw, end.Add(-time.Hour), end)
+
+			assertRows(t, test.want, got)
+			assert.Equal(t, test.wantLog, log.infos())
+		})
+	}
+}
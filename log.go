@@ -0,0 +1,29 @@
+package iotawatt
+
+import "errors"
+
+// logFields returns the fields logged by the module for the operation,
+// followed by the key value pairs. Error values are logged with the
+// device credentials redacted, along with the redacted url and status
+// code of a failed request.
+func (m *Module) logFields(op string, kv ...interface{}) []interface{} {
+	fields := make([]interface{}, 0, 6+len(kv))
+	fields = append(fields, "module", "iotawatt", "id", m.name, "op", op)
+	for i := 0; i < len(kv); i++ {
+		err, ok := kv[i].(error)
+		if !ok {
+			fields = append(fields, kv[i])
+			continue
+		}
+		fields = append(fields, m.redactError(err))
+
+		var rErr *requestError
+		if errors.As(err, &rErr) {
+			fields = append(fields, "url", rErr.url)
+			if rErr.status != 0 {
+				fields = append(fields, "status", rErr.status)
+			}
+		}
+	}
+	return fields
+}
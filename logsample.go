@@ -0,0 +1,39 @@
+package iotawatt
+
+import "time"
+
+// logSampler coalesces repeated identical errors, so an outage logs
+// the first error and then at most one error per window.
+type logSampler struct {
+	window time.Duration
+
+	last       string
+	since      time.Time
+	suppressed int
+}
+
+// allow reports whether the error message should be logged, and the
+// number of identical errors suppressed since it was last logged.
+func (s *logSampler) allow(msg string, now time.Time) (bool, int) {
+	if s.window <= 0 {
+		return true, 0
+	}
+
+	if msg != s.last || now.Sub(s.since) >= s.window {
+		n := s.suppressed
+		s.last = msg
+		s.since = now
+		s.suppressed = 0
+		return true, n
+	}
+	s.suppressed++
+	return false, 0
+}
+
+// reset clears the last error, returning the number of identical
+// errors suppressed since it was last logged.
+func (s *logSampler) reset() int {
+	n := s.suppressed
+	*s = logSampler{window: s.window}
+	return n
+}
@@ -0,0 +1,56 @@
+package iotawatt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSampler_Allow(t *testing.T) {
+	now := time.Now()
+	s := &logSampler{window: time.Minute}
+
+	ok, n := s.allow("timeout", now)
+	assert.True(t, ok)
+	assert.Equal(t, 0, n)
+
+	ok, _ = s.allow("timeout", now.Add(10*time.Second))
+	assert.False(t, ok)
+	ok, _ = s.allow("timeout", now.Add(20*time.Second))
+	assert.False(t, ok)
+
+	ok, n = s.allow("timeout", now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, 2, n)
+
+	ok, _ = s.allow("timeout", now.Add(70*time.Second))
+	assert.False(t, ok)
+	ok, n = s.allow("refused", now.Add(80*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 1, n)
+}
+
+func TestLogSampler_Reset(t *testing.T) {
+	now := time.Now()
+	s := &logSampler{window: time.Minute}
+	s.allow("timeout", now)
+	s.allow("timeout", now)
+
+	n := s.reset()
+
+	assert.Equal(t, 1, n)
+	ok, _ := s.allow("timeout", now)
+	assert.True(t, ok)
+}
+
+func TestLogSampler_WithoutWindow(t *testing.T) {
+	s := &logSampler{}
+
+	for i := 0; i < 3; i++ {
+		ok, n := s.allow("timeout", time.Now())
+
+		assert.True(t, ok)
+		assert.Equal(t, 0, n)
+	}
+}
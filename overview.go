@@ -0,0 +1,47 @@
+package iotawatt
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// fetchOverview fetches the rows of the overview window at low
+// resolution.
+func (m *Module) fetchOverview(ctx context.Context) ([][]float64, error) {
+	qry := func(d *device) url.Values {
+		vals := make(url.Values, len(d.qryVals))
+		for k, v := range d.qryVals {
+			vals[k] = v
+		}
+		vals.Set("begin", "s-"+strconv.Itoa(int(m.cfg.OverviewWindow/time.Second))+"s")
+		vals.Set("resolution", "low")
+		return vals
+	}
+
+	m.fetchMu.Lock()
+	defer m.fetchMu.Unlock()
+
+	return m.fetchDevices(ctx, qry)
+}
+
+// pollOverview fetches and charts the overview series. It is polled
+// after the detail series within the same poll deadline, so either
+// failing leaves the other charted.
+func (m *Module) pollOverview(ctx context.Context) error {
+	raw, err := m.fetchOverview(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	series, _ := m.buildSeries(m.prepareRows(raw, now.Add(-m.cfg.OverviewWindow), now))
+	b, err := json.Marshal(series)
+	if err != nil {
+		return err
+	}
+	_, err = m.eval("%s = %s; %s()", m.jsName("iotaWattOverview"), string(b), m.jsName("updateOverview"))
+	return err
+}
@@ -0,0 +1,69 @@
+package iotawatt
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+)
+
+// PingResult is the result of pinging a device.
+type PingResult struct {
+	// StatusCode is the status code of the query response.
+	StatusCode int
+	// RoundTrip is the time taken by the query.
+	RoundTrip time.Duration
+	// Columns is the number of columns in the returned rows,
+	// including the time.
+	Columns int
+	// SampleTime is the time of the latest returned row, if any.
+	SampleTime time.Time
+}
+
+// Ping queries the last minute of data from the main device in the
+// configuration, returning its reachability. It has no side effects
+// and does not use the ui, e.g. to check a configuration in a setup
+// wizard.
+func Ping(ctx context.Context, cfg *Config) (PingResult, error) {
+	if err := cfg.Validate(); err != nil {
+		return PingResult{}, err
+	}
+
+	dev, err := newDevice(cfg, []string{cfg.URL}, cfg.Inputs)
+	if err != nil {
+		return PingResult{}, fmt.Errorf("iotawatt: %w", err)
+	}
+	qryVals := url.Values{}
+	for k, v := range dev.qryVals {
+		qryVals[k] = v
+	}
+	qryVals.Set("begin", "s-1m")
+
+	m := &Module{cfg: cfg, client: newClient(cfg), metrics: newMetrics()}
+	defer m.client.CloseIdleConnections()
+
+	u := endpointURL(dev.baseURLs[0], apiQueryPath)
+	u.RawQuery = qryVals.Encode()
+
+	start := time.Now()
+	raw, status, err := m.doRequest(ctx, u)
+	res := PingResult{StatusCode: status, RoundTrip: time.Since(start)}
+	if err != nil {
+		return res, fmt.Errorf("iotawatt: could not query %s: %w", redactedURL(u), err)
+	}
+
+	if len(raw) > 0 && len(raw[len(raw)-1]) > 0 {
+		row := raw[len(raw)-1]
+		res.Columns = len(row)
+
+		ts := row[0]
+		if cfg.TimeColumn == "last" {
+			ts = row[len(row)-1]
+		}
+		if !math.IsNaN(ts) {
+			res.SampleTime = time.Unix(int64(ts), 0)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,94 @@
+package iotawatt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		timeColumn string
+		want       PingResult
+		wantErr    require.ErrorAssertionFunc
+	}{
+		{
+			name:   "rows",
+			status: http.StatusOK,
+			body:   `[[1614852000,1,2],[1614852020,3,4]]`,
+			want: PingResult{
+				StatusCode: http.StatusOK,
+				Columns:    3,
+				SampleTime: time.Unix(1614852020, 0),
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:       "time column last",
+			status:     http.StatusOK,
+			body:       `[[1,2,1614852020]]`,
+			timeColumn: "last",
+			want: PingResult{
+				StatusCode: http.StatusOK,
+				Columns:    3,
+				SampleTime: time.Unix(1614852020, 0),
+			},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "no rows",
+			status:  http.StatusOK,
+			body:    `[]`,
+			want:    PingResult{StatusCode: http.StatusOK},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "error status",
+			status:  http.StatusInternalServerError,
+			want:    PingResult{StatusCode: http.StatusInternalServerError},
+			wantErr: require.Error,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var begin string
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				begin = r.URL.Query().Get("begin")
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(test.status)
+				_, _ = rw.Write([]byte(test.body))
+			}))
+			defer srv.Close()
+			cfg := NewConfig()
+			cfg.URL = srv.URL
+			cfg.Inputs = []Input{{Name: "l1"}, {Name: "l2"}}
+			if test.timeColumn != "" {
+				cfg.TimeColumn = test.timeColumn
+			}
+
+			got, err := Ping(context.Background(), cfg)
+
+			test.wantErr(t, err)
+			assert.Equal(t, "s-1m", begin)
+			assert.Equal(t, test.want.StatusCode, got.StatusCode)
+			assert.Equal(t, test.want.Columns, got.Columns)
+			assert.True(t, test.want.SampleTime.Equal(got.SampleTime))
+			assert.Greater(t, got.RoundTrip, time.Duration(0))
+		})
+	}
+}
+
+func TestPing_InvalidConfig(t *testing.T) {
+	_, err := Ping(context.Background(), NewConfig())
+
+	assert.Error(t, err)
+}
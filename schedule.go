@@ -0,0 +1,91 @@
+package iotawatt
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// weekdays are the names of the schedule days, indexed by weekday.
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// schedule is a parsed schedule of the hours charted.
+type schedule struct {
+	days       [7]bool
+	start, end time.Duration
+	loc        *time.Location
+}
+
+// newSchedule parses the schedule, returning nil if there is none.
+func newSchedule(s *Schedule, loc *time.Location) (*schedule, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	sch := &schedule{loc: loc}
+	if len(s.Days) == 0 {
+		for i := range sch.days {
+			sch.days[i] = true
+		}
+	}
+	for _, day := range s.Days {
+		i := indexOf(weekdays, strings.ToLower(day))
+		if i < 0 {
+			return nil, fmt.Errorf("unsupported schedule day %q", day)
+		}
+		sch.days[i] = true
+	}
+
+	var err error
+	if sch.start, err = parseTimeOfDay(s.Start); err != nil {
+		return nil, fmt.Errorf("invalid schedule start: %w", err)
+	}
+	if sch.end, err = parseTimeOfDay(s.End); err != nil {
+		return nil, fmt.Errorf("invalid schedule end: %w", err)
+	}
+	if sch.start == sch.end {
+		return nil, fmt.Errorf("schedule start and end cannot be the same")
+	}
+	return sch, nil
+}
+
+// parseTimeOfDay parses a time of day, e.g. "07:30", returning the
+// time since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains reports whether the unix time falls within the schedule.
+// A schedule ending before it starts spans midnight, with the hours
+// after midnight belonging to the day it started.
+func (s *schedule) contains(ts float64) bool {
+	t := time.Unix(int64(ts), 0).In(s.loc)
+	// The wall clock is used, so the hours hold on daylight saving days.
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	day := int(t.Weekday())
+
+	if s.start < s.end {
+		return s.days[day] && sinceMidnight >= s.start && sinceMidnight < s.end
+	}
+	if sinceMidnight >= s.start {
+		return s.days[day]
+	}
+	return sinceMidnight < s.end && s.days[(day+6)%7]
+}
+
+// filter returns the rows within the schedule. The rows are not
+// modified.
+func (s *schedule) filter(raw [][]float64) [][]float64 {
+	kept := make([][]float64, 0, len(raw))
+	for _, row := range raw {
+		if s.contains(row[0]) {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
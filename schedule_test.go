@@ -0,0 +1,186 @@
+package iotawatt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule_Contains(t *testing.T) {
+	tests := []struct {
+		name  string
+		sched *Schedule
+		at    time.Time
+		want  bool
+	}{
+		{
+			name:  "within hours",
+			sched: &Schedule{Start: "07:00", End: "19:00"},
+			at:    time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC),
+			want:  true,
+		},
+		{
+			name:  "at end",
+			sched: &Schedule{Start: "07:00", End: "19:00"},
+			at:    time.Date(2021, 3, 4, 19, 0, 0, 0, time.UTC),
+			want:  false,
+		},
+		{
+			name:  "before start",
+			sched: &Schedule{Start: "07:00", End: "19:00"},
+			at:    time.Date(2021, 3, 4, 6, 59, 59, 0, time.UTC),
+			want:  false,
+		},
+		{
+			name:  "other day",
+			sched: &Schedule{Days: []string{"Mon"}, Start: "07:00", End: "19:00"},
+			at:    time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC),
+			want:  false,
+		},
+		{
+			name:  "spans midnight before midnight",
+			sched: &Schedule{Days: []string{"thu"}, Start: "22:00", End: "06:00"},
+			at:    time.Date(2021, 3, 4, 23, 0, 0, 0, time.UTC),
+			want:  true,
+		},
+		{
+			name:  "spans midnight after midnight",
+			sched: &Schedule{Days: []string{"thu"}, Start: "22:00", End: "06:00"},
+			at:    time.Date(2021, 3, 5, 5, 0, 0, 0, time.UTC),
+			want:  true,
+		},
+		{
+			name:  "spans midnight after midnight of another day",
+			sched: &Schedule{Days: []string{"thu"}, Start: "22:00", End: "06:00"},
+			at:    time.Date(2021, 3, 4, 5, 0, 0, 0, time.UTC),
+			want:  false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := newSchedule(test.sched, time.UTC)
+			require.NoError(t, err)
+
+			got := s.contains(float64(test.at.Unix()))
+
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestSchedule_ContainsInLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+10", 10*60*60)
+	s, err := newSchedule(&Schedule{Days: []string{"fri"}, Start: "07:00", End: "19:00"}, loc)
+	require.NoError(t, err)
+
+	// Thursday 22:00 UTC is Friday 08:00 in the location.
+	got := s.contains(float64(time.Date(2021, 3, 4, 22, 0, 0, 0, time.UTC).Unix()))
+
+	assert.True(t, got)
+}
+
+func TestNewSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		sched   *Schedule
+		wantNil bool
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "no schedule",
+			wantNil: true,
+			wantErr: require.NoError,
+		},
+		{
+			name:    "valid",
+			sched:   &Schedule{Days: []string{"Mon", "TUE"}, Start: "07:00", End: "19:00"},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "unsupported day",
+			sched:   &Schedule{Days: []string{"monday"}, Start: "07:00", End: "19:00"},
+			wantErr: require.Error,
+		},
+		{
+			name:    "invalid start",
+			sched:   &Schedule{Start: "7am", End: "19:00"},
+			wantErr: require.Error,
+		},
+		{
+			name:    "invalid end",
+			sched:   &Schedule{Start: "07:00", End: "25:00"},
+			wantErr: require.Error,
+		},
+		{
+			name:    "same start and end",
+			sched:   &Schedule{Start: "07:00", End: "07:00"},
+			wantErr: require.Error,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := newSchedule(test.sched, time.UTC)
+
+			test.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, test.wantNil, got == nil)
+		})
+	}
+}
+
+func TestSchedule_FilterExcludesOffHours(t *testing.T) {
+	s, err := newSchedule(&Schedule{Days: []string{"thu", "fri"}, Start: "07:00", End: "19:00"}, time.UTC)
+	require.NoError(t, err)
+	raw := [][]float64{
+		{float64(time.Date(2021, 3, 4, 6, 0, 0, 0, time.UTC).Unix()), 1},
+		{float64(time.Date(2021, 3, 4, 7, 0, 0, 0, time.UTC).Unix()), 2},
+		{float64(time.Date(2021, 3, 4, 18, 0, 0, 0, time.UTC).Unix()), 3},
+		{float64(time.Date(2021, 3, 4, 20, 0, 0, 0, time.UTC).Unix()), 4},
+		{float64(time.Date(2021, 3, 6, 12, 0, 0, 0, time.UTC).Unix()), 5},
+	}
+
+	got := s.filter(raw)
+
+	assertRows(t, [][]float64{raw[1], raw[2]}, got)
+	assert.Len(t, raw, 5)
+}
+
+func TestModule_PollScheduleExcludesPartOfWindow(t *testing.T) {
+	now := time.Now().UTC()
+	ts := now.Unix() / 20 * 20
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,50],[%d,60],[%d,70]]`, ts-2*3600, ts-3600, ts)
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Window = 3 * time.Hour
+	cfg.Timezone = "UTC"
+	cfg.ShowStats = true
+	cfg.WarmupPolls = 0
+	// Only the hour of the middle row is scheduled.
+	cfg.Schedule = &Schedule{
+		Start: now.Add(-90 * time.Minute).Format("15:04"),
+		End:   now.Add(-30 * time.Minute).Format("15:04"),
+	}
+	m, ui, _ := newTestModule(t, cfg)
+
+	err := m.poll(context.Background())
+
+	require.NoError(t, err)
+	assert.True(t, ui.evaluated(fmt.Sprintf(`iotaWattSeries_test = [{"name":"main","data":[[%d,60]]}]`, ts-3600)))
+	assert.True(t, ui.evaluated("'min 60.0 W &middot; avg 60.0 W &middot; max 60.0 W'"))
+	// The current value is live, outside the schedule.
+	assert.True(t, ui.evaluated("70<sel>.0 W"))
+}
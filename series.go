@@ -0,0 +1,624 @@
+package iotawatt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Point is a chart point of time and value. A NaN value
+// represents a missing reading and is encoded as null.
+type Point [2]float64
+
+// MarshalJSON encodes the point, encoding missing values as null.
+func (p Point) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(p[1]) {
+		return []byte("[" + strconv.FormatFloat(p[0], 'f', -1, 64) + ",null]"), nil
+	}
+	return json.Marshal([2]float64(p))
+}
+
+// UnmarshalJSON decodes the point, decoding null as a missing value.
+func (p *Point) UnmarshalJSON(b []byte) error {
+	var v [2]*float64
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v[0] == nil {
+		return errors.New("point has no timestamp")
+	}
+	p[0], p[1] = *v[0], math.NaN()
+	if v[1] != nil {
+		p[1] = *v[1]
+	}
+	return nil
+}
+
+// Series is a charted series of an input or combined inputs.
+type Series struct {
+	// Name is the name shown in the chart legend.
+	Name string `json:"name"`
+	// Data are the points of the series in time order.
+	Data []Point `json:"data"`
+	// YAxis is the chart axis index, 1 for the right axis.
+	YAxis int `json:"yAxis,omitempty"`
+	// Visible is only set on inputs whose visibility was set.
+	Visible *bool `json:"visible,omitempty"`
+}
+
+// hidden returns if the series is hidden.
+func (s Series) hidden() bool {
+	return s.Visible != nil && !*s.Visible
+}
+
+// combined is a charted series summed from a group of input columns.
+type combined struct {
+	name string
+	cols []int
+	axis int
+}
+
+// newCombined resolves the combined input groups to their response columns,
+// returning the groups ordered by name and the set of columns they contain.
+func newCombined(inputs []Input, combine map[string][]string) ([]combined, map[int]bool, error) {
+	if len(combine) == 0 {
+		return nil, nil, nil
+	}
+
+	cols := make(map[string]int, len(inputs))
+	for i, in := range inputs {
+		cols[in.Name] = i + 1
+	}
+
+	names := make([]string, 0, len(combine))
+	for name := range combine {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]combined, 0, len(names))
+	members := map[int]bool{}
+	for _, name := range names {
+		c := combined{name: name}
+		for _, in := range combine[name] {
+			col, ok := cols[in]
+			if !ok {
+				return nil, nil, fmt.Errorf("combined input %q is not a configured input", in)
+			}
+			if len(c.cols) == 0 {
+				c.axis = inputs[col-1].axis()
+			}
+			c.cols = append(c.cols, col)
+			members[col] = true
+		}
+		res = append(res, c)
+	}
+	return res, members, nil
+}
+
+// newTotals returns the response columns aggregated into the current value.
+// Inputs on the right axis and inputs other than real power, i.e. apparent
+// and reactive power and power factors, are never part of the total.
+func newTotals(inputs []Input, exclude []string) ([]int, error) {
+	excluded := make(map[string]bool, len(exclude))
+	for _, ex := range exclude {
+		excluded[ex] = true
+	}
+
+	totals := make([]int, 0, len(inputs))
+	for i, in := range inputs {
+		if excluded[in.Name] {
+			delete(excluded, in.Name)
+			continue
+		}
+		if in.axis() != 0 || in.kind() != "power" {
+			continue
+		}
+		totals = append(totals, i+1)
+	}
+	for ex := range excluded {
+		return nil, fmt.Errorf("excluded input %q is not a configured input", ex)
+	}
+	return totals, nil
+}
+
+// scrubPowerFactors replaces power factors outside of -1 to 1 in the
+// rows with missing values, returning the number of values replaced.
+func (m *Module) scrubPowerFactors(raw [][]float64) int {
+	var n int
+	for i, in := range m.inputs {
+		if in.kind() != "pf" {
+			continue
+		}
+		for _, row := range raw {
+			if v := row[i+1]; v < -1 || v > 1 {
+				row[i+1] = math.NaN()
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// applyNoiseFloors replaces readings with a magnitude below the noise
+// floor of their input with zero.
+func (m *Module) applyNoiseFloors(raw [][]float64) {
+	for i, in := range m.inputs {
+		kind := in.kind()
+		if kind == "pf" {
+			continue
+		}
+		// The noise floor is in watts, so only an input override
+		// applies to inputs in other units.
+		var floor float64
+		switch {
+		case in.NoiseFloor != nil:
+			floor = *in.NoiseFloor
+		case kind != "other":
+			floor = m.cfg.NoiseFloor
+		}
+		if floor <= 0 {
+			continue
+		}
+		for _, row := range raw {
+			if math.Abs(row[i+1]) < floor {
+				row[i+1] = 0
+			}
+		}
+	}
+}
+
+// scrubInfinite replaces infinite values in the rows with missing
+// values, returning the number of values replaced.
+func scrubInfinite(raw [][]float64) int {
+	var n int
+	for _, row := range raw {
+		for j := 1; j < len(row); j++ {
+			if math.IsInf(row[j], 0) {
+				row[j] = math.NaN()
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// dropImplausibleTimes removes the rows timestamped outside
+// [min, max] in unix seconds, returning the kept rows and the number
+// dropped.
+func dropImplausibleTimes(raw [][]float64, min, max float64) ([][]float64, int) {
+	kept := raw[:0]
+	for _, row := range raw {
+		if len(row) == 0 || row[0] < min || row[0] > max {
+			continue
+		}
+		kept = append(kept, row)
+	}
+	return kept, len(raw) - len(kept)
+}
+
+// bucketRows averages the sorted rows into buckets of the given
+// size in seconds, timestamped at the start of each bucket.
+func bucketRows(raw [][]float64, size float64) [][]float64 {
+	for _, row := range raw {
+		row[0] = math.Floor(row[0]/size) * size
+	}
+	return mergeDuplicates(raw, "average")
+}
+
+// sortRows sorts the rows by ascending timestamp.
+func sortRows(raw [][]float64) {
+	less := func(i, j int) bool { return raw[i][0] < raw[j][0] }
+	if sort.SliceIsSorted(raw, less) {
+		return
+	}
+	sort.SliceStable(raw, less)
+}
+
+// mergeDuplicates merges rows with the same timestamp into a single row,
+// either averaging their values or keeping the last row.
+func mergeDuplicates(raw [][]float64, mode string) [][]float64 {
+	idx := make(map[float64]int, len(raw))
+	var dups map[int][][]float64
+	merged := make([][]float64, 0, len(raw))
+	for _, row := range raw {
+		i, ok := idx[row[0]]
+		if !ok {
+			idx[row[0]] = len(merged)
+			merged = append(merged, row)
+			continue
+		}
+
+		if mode == "last" {
+			merged[i] = row
+			continue
+		}
+		if dups == nil {
+			dups = map[int][][]float64{}
+		}
+		if len(dups[i]) == 0 {
+			dups[i] = append(dups[i], merged[i])
+		}
+		dups[i] = append(dups[i], row)
+	}
+
+	for i, rows := range dups {
+		avg := make([]float64, len(rows[0]))
+		avg[0] = rows[0][0]
+		vals := make([]float64, len(rows))
+		for j := 1; j < len(avg); j++ {
+			for k, row := range rows {
+				vals[k] = math.NaN()
+				if j < len(row) {
+					vals[k] = row[j]
+				}
+			}
+			avg[j] = mean(vals)
+		}
+		merged[i] = avg
+	}
+	return merged
+}
+
+// buildSeries builds the charted series from the response rows, returning
+// the series and the charted timestamps. Inputs are charted in order,
+// followed by the combined series.
+func (m *Module) buildSeries(raw [][]float64) ([]Series, []float64) {
+	series, times, _ := m.buildSeriesInto(raw, nil)
+	return series, times
+}
+
+// buildSeriesInto builds the series like buildSeries, slicing the points
+// of every series from buf, which is grown when too small and returned.
+// The series are only valid until buf is reused.
+func (m *Module) buildSeriesInto(raw [][]float64, buf []Point) ([]Series, []float64, []Point) {
+	l := len(m.inputs)
+
+	series := make([]Series, l+len(m.combined))
+	for i, in := range m.inputs {
+		series[i].Name = in.legendName()
+		series[i].YAxis = in.axis()
+		if visible, ok := m.visibility.get(in.Name); ok {
+			series[i].Visible = &visible
+		}
+	}
+	for i, c := range m.combined {
+		series[l+i].Name = c.name
+		series[l+i].YAxis = c.axis
+	}
+	var times []float64
+	if n := chartedRows(raw); n > 0 {
+		if cap(buf) < n*len(series) {
+			buf = make([]Point, n*len(series))
+		}
+		times = make([]float64, 0, n)
+		for i := range series {
+			// The capacity is capped so a series never appends into the next.
+			series[i].Data = buf[i*n : i*n : (i+1)*n]
+		}
+		// The combined values are summed from a buffer shared by all rows.
+		var vals []float64
+		for _, row := range raw {
+			if int(row[0])%20 != 0 {
+				continue
+			}
+			ts := row[0]
+			if m.cfg.TimestampMillis {
+				ts *= 1000
+			}
+			times = append(times, ts)
+			for j := 1; j <= l; j++ {
+				series[j-1].Data = append(series[j-1].Data, Point{ts, row[j]})
+			}
+			for j, c := range m.combined {
+				vals = vals[:0]
+				for _, col := range c.cols {
+					vals = append(vals, row[col])
+				}
+				series[l+j].Data = append(series[l+j].Data, Point{ts, sum(vals)})
+			}
+		}
+	}
+
+	if m.cfg.GapFill != "none" {
+		maxGap := m.cfg.MaxGap.Seconds()
+		if m.cfg.TimestampMillis {
+			maxGap *= 1000
+		}
+		for i := range series {
+			fillGaps(series[i].Data, m.cfg.GapFill, maxGap)
+		}
+	}
+	if m.cfg.TrimNulls {
+		for i := range series {
+			series[i].Data = trimNulls(series[i].Data)
+		}
+	}
+	if m.cfg.Decimation == "minmax" && m.cfg.MaxPoints > 0 {
+		for i := range series {
+			series[i].Data = decimateMinMax(series[i].Data, m.cfg.MaxPoints)
+		}
+	}
+
+	if len(m.hidden) == 0 {
+		return series, times, buf
+	}
+	visible := series[:0]
+	for i, s := range series {
+		if i < l && m.hidden[i+1] {
+			continue
+		}
+		visible = append(visible, s)
+	}
+	return visible, times, buf
+}
+
+// copySeries copies the series into dst and their points into pts,
+// reusing them when large enough, and returns the copies.
+func copySeries(dst []Series, pts []Point, src []Series) ([]Series, []Point) {
+	var n int
+	for _, s := range src {
+		n += len(s.Data)
+	}
+	if cap(pts) < n {
+		pts = make([]Point, n)
+	}
+	pts = pts[:n]
+
+	dst = append(dst[:0], src...)
+	var off int
+	for i, s := range src {
+		if s.Data == nil {
+			continue
+		}
+		end := off + copy(pts[off:], s.Data)
+		dst[i].Data = pts[off:end:end]
+		off = end
+	}
+	return dst, pts
+}
+
+// chartedRows returns the number of rows charted.
+func chartedRows(raw [][]float64) int {
+	var n int
+	for _, row := range raw {
+		if int(row[0])%20 == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// current returns the current total from the configured row of the
+// sorted rows. The total is missing if there are no rows.
+func (m *Module) current(raw [][]float64) float64 {
+	if len(raw) == 0 {
+		return math.NaN()
+	}
+
+	switch m.cfg.CurrentRow {
+	case "earliest":
+		return m.total(raw[0])
+	case "max":
+		highest := math.NaN()
+		for _, row := range raw {
+			if total := m.total(row); math.IsNaN(highest) || total > highest {
+				highest = total
+			}
+		}
+		return highest
+	default:
+		return m.total(raw[len(raw)-1])
+	}
+}
+
+// stats returns the minimum, average and maximum total of the rows,
+// ignoring rows without values.
+func (m *Module) stats(raw [][]float64) (lo, avg, hi float64) {
+	totals := make([]float64, 0, len(raw))
+	lo, hi = math.NaN(), math.NaN()
+	for _, row := range raw {
+		total := m.total(row)
+		if math.IsNaN(total) {
+			continue
+		}
+		if math.IsNaN(lo) || total < lo {
+			lo = total
+		}
+		if math.IsNaN(hi) || total > hi {
+			hi = total
+		}
+		totals = append(totals, total)
+	}
+	return lo, mean(totals), hi
+}
+
+// trend returns whether the total is "up", "down" or "flat" from the
+// slope over the last samples, in watts per minute.
+func (m *Module) trend(raw [][]float64) string {
+	var first, last []float64
+	var n int
+	for i := len(raw) - 1; i >= 0 && n < m.cfg.TrendSamples; i-- {
+		total := m.total(raw[i])
+		if math.IsNaN(total) {
+			continue
+		}
+		sample := []float64{raw[i][0], total}
+		if last == nil {
+			last = sample
+		}
+		first = sample
+		n++
+	}
+	if n < 2 || last[0] == first[0] {
+		return "flat"
+	}
+
+	slope := (last[1] - first[1]) / (last[0] - first[0]) * 60
+	switch {
+	case slope > m.cfg.TrendThreshold:
+		return "up"
+	case slope < -m.cfg.TrendThreshold:
+		return "down"
+	default:
+		return "flat"
+	}
+}
+
+// total aggregates the values of the total columns in the row.
+func (m *Module) total(row []float64) float64 {
+	if len(m.totals) == 1 {
+		// The sum and mean of a single value is the value, with
+		// negative zero summed to zero.
+		if v := row[m.totals[0]]; v != 0 {
+			return v
+		}
+		return 0
+	}
+
+	// Aggregated in place, as the total is taken for every row.
+	total, n := 0.0, 0
+	for _, col := range m.totals {
+		if v := row[col]; !math.IsNaN(v) {
+			total += v
+			n++
+		}
+	}
+	switch {
+	case n == 0:
+		return math.NaN()
+	case m.cfg.Aggregate == "mean":
+		return total / float64(n)
+	default:
+		return total
+	}
+}
+
+// sum returns the sum of the given values, ignoring missing values.
+// If all values are missing, the sum is missing.
+func sum(vals []float64) float64 {
+	total := math.NaN()
+	for _, v := range vals {
+		if math.IsNaN(v) {
+			continue
+		}
+		if math.IsNaN(total) {
+			total = 0
+		}
+		total += v
+	}
+	return total
+}
+
+// mean returns the mean of the given values, ignoring missing values.
+// If all values are missing, the mean is missing.
+func mean(vals []float64) float64 {
+	var total float64
+	var n int
+	for _, v := range vals {
+		if math.IsNaN(v) {
+			continue
+		}
+		total += v
+		n++
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	return total / float64(n)
+}
+
+// fillGaps fills runs of missing points in place, either linearly
+// between the surrounding points or with zero. Gaps spanning more
+// than maxGap between the surrounding points, and missing points at
+// either end, are left missing so outages remain visible.
+func fillGaps(data []Point, mode string, maxGap float64) {
+	prev := -1
+	for i, p := range data {
+		if math.IsNaN(p[1]) {
+			continue
+		}
+		if prev >= 0 && i-prev > 1 && p[0]-data[prev][0] <= maxGap {
+			for j := prev + 1; j < i; j++ {
+				switch mode {
+				case "zero":
+					data[j][1] = 0
+				default:
+					frac := (data[j][0] - data[prev][0]) / (p[0] - data[prev][0])
+					data[j][1] = data[prev][1] + (p[1]-data[prev][1])*frac
+				}
+			}
+		}
+		prev = i
+	}
+}
+
+// trimNulls removes the missing points at either end of the data,
+// keeping the gaps in between.
+func trimNulls(data []Point) []Point {
+	for len(data) > 0 && math.IsNaN(data[0][1]) {
+		data = data[1:]
+	}
+	for len(data) > 0 && math.IsNaN(data[len(data)-1][1]) {
+		data = data[:len(data)-1]
+	}
+	return data
+}
+
+// decimateMinMax reduces the points to at most max points by
+// splitting them into buckets and keeping the minimum and maximum
+// point of each bucket in time order, so peaks are not lost.
+// Buckets without values keep a single missing point.
+func decimateMinMax(data []Point, max int) []Point {
+	buckets := max / 2
+	if len(data) <= max || buckets == 0 {
+		return data
+	}
+
+	out := make([]Point, 0, 2*buckets)
+	for b := 0; b < buckets; b++ {
+		bucket := data[b*len(data)/buckets : (b+1)*len(data)/buckets]
+		if len(bucket) == 0 {
+			continue
+		}
+
+		lo, hi := -1, -1
+		for i, p := range bucket {
+			if math.IsNaN(p[1]) {
+				continue
+			}
+			if lo == -1 || p[1] < bucket[lo][1] {
+				lo = i
+			}
+			if hi == -1 || p[1] > bucket[hi][1] {
+				hi = i
+			}
+		}
+		switch {
+		case lo == -1:
+			out = append(out, bucket[0])
+		case lo == hi:
+			out = append(out, bucket[lo])
+		case lo < hi:
+			out = append(out, bucket[lo], bucket[hi])
+		default:
+			out = append(out, bucket[hi], bucket[lo])
+		}
+	}
+	return out
+}
+
+// hasNegative reports whether any of the series has a negative value.
+func hasNegative(series []Series) bool {
+	for _, s := range series {
+		for _, p := range s.Data {
+			if p[1] < 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,401 @@
+package iotawatt
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModule_BuildSeriesCombine(t *testing.T) {
+	tests := []struct {
+		name     string
+		hide     bool
+		wantName []string
+	}{
+		{
+			name:     "with inputs",
+			wantName: []string{"l1", "l2", "solar", "mains"},
+		},
+		{
+			name:     "hiding combined inputs",
+			hide:     true,
+			wantName: []string{"solar", "mains"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Inputs = []Input{{Name: "l1"}, {Name: "l2"}, {Name: "solar"}}
+			cfg.Combine = map[string][]string{"mains": {"l1", "l2"}}
+			cfg.HideCombined = test.hide
+			m, _, _ := newTestModule(t, cfg)
+			raw := [][]float64{{20, 1, 2, 5}, {40, nan, 3, 6}, {60, nan, nan, 7}}
+
+			series, _ := m.buildSeries(raw)
+
+			names := make([]string, len(series))
+			for i, s := range series {
+				names[i] = s.Name
+			}
+			require.Equal(t, test.wantName, names)
+			assertPoints(t, []Point{{20, 3}, {40, 3}, {60, nan}}, series[len(series)-1].Data)
+			assert.Equal(t, 8.0, m.total(raw[0]))
+		})
+	}
+}
+
+func assertPoints(t *testing.T, want, got []Point) {
+	t.Helper()
+
+	require.Len(t, got, len(want))
+	for i := range want {
+		assertFloat(t, want[i][0], got[i][0], "point %d time", i)
+		assertFloat(t, want[i][1], got[i][1], "point %d value", i)
+	}
+}
+
+func TestModule_Aggregate(t *testing.T) {
+	tests := []struct {
+		name        string
+		aggregate   string
+		wantCurrent float64
+		wantLo      float64
+		wantAvg     float64
+		wantHi      float64
+	}{
+		{
+			name:        "sum",
+			aggregate:   "sum",
+			wantCurrent: 6,
+			wantLo:      4,
+			wantAvg:     5,
+			wantHi:      6,
+		},
+		{
+			name:        "mean",
+			aggregate:   "mean",
+			wantCurrent: 3,
+			wantLo:      2,
+			wantAvg:     2.5,
+			wantHi:      3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Inputs = []Input{{Name: "l1"}, {Name: "l2"}}
+			cfg.Aggregate = test.aggregate
+			m, _, _ := newTestModule(t, cfg)
+			raw := [][]float64{{20, 1, 3}, {40, nan, nan}, {60, 2, 4}}
+
+			current := m.current(raw)
+			lo, avg, hi := m.stats(raw)
+
+			assert.Equal(t, test.wantCurrent, current)
+			assert.Equal(t, test.wantLo, lo)
+			assert.Equal(t, test.wantAvg, avg)
+			assert.Equal(t, test.wantHi, hi)
+		})
+	}
+}
+
+func TestModule_ExcludeFromTotal(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Inputs = []Input{{Name: "mains"}, {Name: "solar"}}
+	cfg.ExcludeFromTotal = []string{"solar"}
+	m, _, _ := newTestModule(t, cfg)
+	raw := [][]float64{{20, 1000, -400}, {40, 1200, -800}}
+
+	current := m.current(raw)
+	series, _ := m.buildSeries(raw)
+
+	assert.Equal(t, 1200.0, current)
+	require.Len(t, series, 2)
+	assertPoints(t, []Point{{20, -400}, {40, -800}}, series[1].Data)
+}
+
+func TestNewTotals_UnknownExcludedInput(t *testing.T) {
+	_, err := newTotals([]Input{{Name: "mains"}}, []string{"solar"})
+
+	assert.Error(t, err)
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  [][]float64
+		mode string
+		want [][]float64
+	}{
+		{
+			name: "no duplicates",
+			raw:  [][]float64{{20, 1}, {40, 2}},
+			mode: "average",
+			want: [][]float64{{20, 1}, {40, 2}},
+		},
+		{
+			name: "average",
+			raw:  [][]float64{{20, 1, 4}, {40, 2, 5}, {20, 3, nan}},
+			mode: "average",
+			want: [][]float64{{20, 2, 4}, {40, 2, 5}},
+		},
+		{
+			name: "average of missing values",
+			raw:  [][]float64{{20, nan}, {20, nan}},
+			mode: "average",
+			want: [][]float64{{20, nan}},
+		},
+		{
+			name: "last",
+			raw:  [][]float64{{20, 1}, {40, 2}, {20, 3}},
+			mode: "last",
+			want: [][]float64{{20, 3}, {40, 2}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := mergeDuplicates(test.raw, test.mode)
+
+			assertRows(t, test.want, got)
+		})
+	}
+}
+
+func TestModule_CurrentRow(t *testing.T) {
+	tests := []struct {
+		name       string
+		currentRow string
+		raw        [][]float64
+		want       float64
+	}{
+		{
+			name:       "latest ascending",
+			currentRow: "latest",
+			raw:        [][]float64{{20, 1}, {40, 3}, {60, 2}},
+			want:       2,
+		},
+		{
+			name:       "latest descending",
+			currentRow: "latest",
+			raw:        [][]float64{{60, 2}, {40, 3}, {20, 1}},
+			want:       2,
+		},
+		{
+			name:       "earliest ascending",
+			currentRow: "earliest",
+			raw:        [][]float64{{20, 1}, {40, 3}, {60, 2}},
+			want:       1,
+		},
+		{
+			name:       "earliest descending",
+			currentRow: "earliest",
+			raw:        [][]float64{{60, 2}, {40, 3}, {20, 1}},
+			want:       1,
+		},
+		{
+			name:       "max",
+			currentRow: "max",
+			raw:        [][]float64{{60, 2}, {40, 3}, {20, 1}},
+			want:       3,
+		},
+		{
+			name:       "no rows",
+			currentRow: "latest",
+			raw:        [][]float64{},
+			want:       nan,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.CurrentRow = test.currentRow
+			m, _, _ := newTestModule(t, cfg)
+			sortRows(test.raw)
+
+			got := m.current(test.raw)
+
+			assertFloat(t, test.want, got)
+		})
+	}
+}
+
+func TestDecimateMinMax(t *testing.T) {
+	tests := []struct {
+		name string
+		data []Point
+		max  int
+		want []Point
+	}{
+		{
+			name: "within max",
+			data: []Point{{0, 1}, {20, 2}},
+			max:  4,
+			want: []Point{{0, 1}, {20, 2}},
+		},
+		{
+			name: "keeps min and max in time order",
+			data: []Point{{0, 5}, {20, 9}, {40, 1}, {60, 3}, {80, 2}, {100, 7}, {120, 4}, {140, 6}},
+			max:  4,
+			want: []Point{{20, 9}, {40, 1}, {80, 2}, {100, 7}},
+		},
+		{
+			name: "missing bucket",
+			data: []Point{{0, nan}, {20, nan}, {40, 1}, {60, 3}},
+			max:  2,
+			want: []Point{{40, 1}, {60, 3}},
+		},
+		{
+			name: "bucket without values",
+			data: []Point{{0, nan}, {20, nan}, {40, nan}, {60, 1}, {80, 3}, {100, 2}, {120, 4}, {140, nan}, {160, 5}},
+			max:  6,
+			want: []Point{{0, nan}, {60, 1}, {80, 3}, {120, 4}, {160, 5}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := decimateMinMax(test.data, test.max)
+
+			assertPoints(t, test.want, got)
+		})
+	}
+}
+
+func TestFillGaps(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []Point
+		mode   string
+		maxGap float64
+		want   []Point
+	}{
+		{
+			name:   "linear",
+			data:   []Point{{0, 10}, {20, nan}, {40, nan}, {60, 40}},
+			mode:   "linear",
+			maxGap: 60,
+			want:   []Point{{0, 10}, {20, 20}, {40, 30}, {60, 40}},
+		},
+		{
+			name:   "zero",
+			data:   []Point{{0, 10}, {20, nan}, {40, 40}},
+			mode:   "zero",
+			maxGap: 60,
+			want:   []Point{{0, 10}, {20, 0}, {40, 40}},
+		},
+		{
+			name:   "gap longer than max",
+			data:   []Point{{0, 10}, {20, nan}, {40, nan}, {60, 40}},
+			mode:   "linear",
+			maxGap: 40,
+			want:   []Point{{0, 10}, {20, nan}, {40, nan}, {60, 40}},
+		},
+		{
+			name:   "missing ends",
+			data:   []Point{{0, nan}, {20, 10}, {40, nan}},
+			mode:   "zero",
+			maxGap: 60,
+			want:   []Point{{0, nan}, {20, 10}, {40, nan}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fillGaps(test.data, test.mode, test.maxGap)
+
+			assertPoints(t, test.want, test.data)
+		})
+	}
+}
+
+func TestTrimNulls(t *testing.T) {
+	data := []Point{{0, nan}, {20, 1}, {40, nan}, {60, 2}, {80, nan}}
+
+	got := trimNulls(data)
+
+	assertPoints(t, []Point{{20, 1}, {40, nan}, {60, 2}}, got)
+	assert.Empty(t, trimNulls([]Point{{0, nan}}))
+}
+
+func TestPoint_JSON(t *testing.T) {
+	b, err := json.Marshal([]Point{{20, 1.5}, {40, nan}})
+	require.NoError(t, err)
+	assert.Equal(t, `[[20,1.5],[40,null]]`, string(b))
+
+	var got []Point
+	err = json.Unmarshal(b, &got)
+	require.NoError(t, err)
+	assertPoints(t, []Point{{20, 1.5}, {40, nan}}, got)
+
+	err = json.Unmarshal([]byte(`[[null,1]]`), &got)
+	assert.Error(t, err)
+}
+
+func BenchmarkBuildSeries(b *testing.B) {
+	const inputs, rows = 24, 4320
+
+	m := &Module{cfg: NewConfig(), visibility: newVisibility("")}
+	for i := 0; i < inputs; i++ {
+		m.inputs = append(m.inputs, Input{Name: "input" + strconv.Itoa(i)})
+	}
+	m.combined = []combined{{name: "all", cols: []int{1, 2, 3, 4}}}
+
+	raw := make([][]float64, rows)
+	for i := range raw {
+		raw[i] = make([]float64, inputs+1)
+		raw[i][0] = float64(i * 20)
+		for j := 1; j <= inputs; j++ {
+			raw[i][j] = float64(i + j)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	// The points are reused across builds like polls do.
+	var buf []Point
+	for i := 0; i < b.N; i++ {
+		_, _, buf = m.buildSeriesInto(raw, buf)
+	}
+}
+
+func TestModule_BuildSeriesIntoReusesBuffer(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Inputs = []Input{{Name: "l1"}, {Name: "l2"}}
+	m, _, _ := newTestModule(t, cfg)
+
+	series, _, buf := m.buildSeriesInto([][]float64{{20, 1, 2}, {40, 3, 4}}, nil)
+	assertPoints(t, []Point{{20, 1}, {40, 3}}, series[0].Data)
+	assertPoints(t, []Point{{20, 2}, {40, 4}}, series[1].Data)
+
+	series, _, got := m.buildSeriesInto([][]float64{{60, 5, 6}}, buf)
+
+	assert.Same(t, &buf[0], &got[0])
+	assertPoints(t, []Point{{60, 5}}, series[0].Data)
+	assertPoints(t, []Point{{60, 6}}, series[1].Data)
+	assert.Equal(t, 1, cap(series[0].Data))
+}
+
+func TestCopySeries(t *testing.T) {
+	src := []Series{
+		{Name: "l1", Data: []Point{{20, 1}, {40, 2}}},
+		{Name: "l2"},
+		{Name: "l3", Data: []Point{{20, 3}}},
+	}
+
+	dst, pts := copySeries(nil, nil, src)
+	src[0].Data[0] = Point{20, 9}
+
+	require.Len(t, dst, 3)
+	assert.Len(t, pts, 3)
+	assertPoints(t, []Point{{20, 1}, {40, 2}}, dst[0].Data)
+	assert.Nil(t, dst[1].Data)
+	assertPoints(t, []Point{{20, 3}}, dst[2].Data)
+	assert.Equal(t, []string{"l1", "l2", "l3"}, []string{dst[0].Name, dst[1].Name, dst[2].Name})
+}
@@ -0,0 +1,143 @@
+package iotawatt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// session accumulates the energy used since it was last reset.
+type session struct {
+	mu sync.Mutex
+
+	path     string
+	interval time.Duration
+	lastSave time.Time
+
+	Start    time.Time `json:"start"`
+	WattHour float64   `json:"wattHour"`
+	LastTime float64   `json:"lastTime"`
+}
+
+// loadSession loads the session from the file at path, starting
+// a new session if the file does not exist. The session is saved at
+// most once per interval as energy is added.
+func loadSession(path string, interval time.Duration) (*session, error) {
+	s := &session{path: path, interval: interval, Start: time.Now()}
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	switch {
+	case os.IsNotExist(err):
+		return s, nil
+	case err != nil:
+		return nil, fmt.Errorf("could not read session: %w", err)
+	}
+	if err = json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("could not parse session: %w", err)
+	}
+	return s, nil
+}
+
+// add integrates the power in watts at the unix timestamp into the session
+// energy, over at most maxGap seconds since the last reading.
+func (s *session) add(ts, watt, maxGap float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.WattHour += integrateEnergy(watt, s.LastTime, ts, maxGap)
+	if ts > s.LastTime {
+		s.LastTime = ts
+	}
+	if time.Since(s.lastSave) < s.interval {
+		return s.WattHour, nil
+	}
+	return s.WattHour, s.save()
+}
+
+// integrateEnergy returns the energy in watt hours of the power in watts
+// from the unix time of the last reading to ts. The time is limited to
+// maxGap seconds, so a restart or an outage does not count the power
+// over the whole gap.
+func integrateEnergy(watt, last, ts, maxGap float64) float64 {
+	if last <= 0 || ts <= last {
+		return 0
+	}
+	return watt * math.Min(ts-last, maxGap) / 3600
+}
+
+// energyGap returns the longest time in seconds integrated between two
+// readings: the poll interval, or the step of the rows when longer.
+func (m *Module) energyGap(raw [][]float64) float64 {
+	gap := m.interval.Seconds()
+	if n := len(raw); n > 1 {
+		gap = math.Max(gap, raw[n-1][0]-raw[n-2][0])
+	}
+	return gap
+}
+
+// flush saves the session.
+func (s *session) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.save()
+}
+
+// reset starts a new session.
+func (s *session) reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Start = time.Now()
+	s.WattHour = 0
+	s.LastTime = 0
+	return s.save()
+}
+
+// save writes the session to its file. The lock must be held.
+func (s *session) save() error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("could not encode session: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("could not write session: %w", err)
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("could not write session: %w", err)
+	}
+	s.lastSave = time.Now()
+	return nil
+}
+
+// ResetEnergyCounter resets the session energy counter.
+func (m *Module) ResetEnergyCounter() error {
+	if m.session == nil {
+		return nil
+	}
+	if err := m.session.reset(); err != nil {
+		return err
+	}
+	// Called from outside the poll goroutine, so never batched.
+	_, err := m.ui.Eval(sessionScript, m.name, formatKWh(0))
+	return err
+}
+
+// sessionScript renders the session energy.
+const sessionScript = "document.querySelector('#%s .session .kwh').innerHTML = '%s<sel> kWh</sel>'"
+
+func formatKWh(wh float64) string {
+	return strconv.FormatFloat(wh/1000, 'f', 2, 64)
+}
+
+func (m *Module) renderSession(wh float64) error {
+	_, err := m.eval(sessionScript, m.name, formatKWh(wh))
+	return err
+}
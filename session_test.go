@@ -0,0 +1,104 @@
+package iotawatt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	s, err := loadSession(path, time.Hour)
+	require.NoError(t, err)
+	_, err = s.add(1614852000, 1000, 60)
+	require.NoError(t, err)
+	_, err = s.add(1614852060, 1000, 60)
+	require.NoError(t, err)
+
+	err = s.flush()
+	require.NoError(t, err)
+
+	got, err := loadSession(path, time.Hour)
+	require.NoError(t, err)
+	assert.InDelta(t, 1000.0/60, got.WattHour, 1e-9)
+	assert.Equal(t, 1614852060.0, got.LastTime)
+	assert.True(t, s.Start.Equal(got.Start))
+}
+
+func TestSession_LimitsGap(t *testing.T) {
+	s, err := loadSession(filepath.Join(t.TempDir(), "session.json"), time.Hour)
+	require.NoError(t, err)
+	_, err = s.add(1614852000, 1000, 60)
+	require.NoError(t, err)
+
+	wh, err := s.add(1614852000+86400, 1000, 60)
+
+	require.NoError(t, err)
+	assert.InDelta(t, 1000.0/60, wh, 1e-9)
+}
+
+func TestSession_Reset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	s, err := loadSession(path, time.Hour)
+	require.NoError(t, err)
+	_, err = s.add(1614852000, 1000, 60)
+	require.NoError(t, err)
+	_, err = s.add(1614852060, 1000, 60)
+	require.NoError(t, err)
+
+	err = s.reset()
+
+	require.NoError(t, err)
+	got, err := loadSession(path, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, got.WattHour)
+	assert.Equal(t, 0.0, got.LastTime)
+}
+
+func TestSession_CorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	err := os.WriteFile(path, []byte("{"), 0o600)
+	require.NoError(t, err)
+
+	_, err = loadSession(path, time.Hour)
+
+	assert.Error(t, err)
+}
+
+func TestModule_EnergyGap(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  [][]float64
+		want float64
+	}{
+		{
+			name: "interval",
+			raw:  [][]float64{{1614852000, 1}, {1614852010, 1}},
+			want: 60,
+		},
+		{
+			name: "step",
+			raw:  [][]float64{{1614852000, 1}, {1614852120, 1}},
+			want: 120,
+		},
+		{
+			name: "single row",
+			raw:  [][]float64{{1614852000, 1}},
+			want: 60,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &Module{interval: time.Minute}
+
+			got := m.energyGap(test.raw)
+
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
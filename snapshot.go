@@ -0,0 +1,88 @@
+package iotawatt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// loadSnapshot loads the snapshot from the file at path, returning
+// nil if the file does not exist.
+func loadSnapshot(path string) (*snapshot, error) {
+	b, err := os.ReadFile(filepath.Clean(path))
+	switch {
+	case os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("could not read snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err = json.Unmarshal(b, &snap); err != nil {
+		return nil, fmt.Errorf("could not parse snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// saveSnapshot writes the snapshot to the file at path.
+func saveSnapshot(path string, snap *snapshot) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("could not encode snapshot: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("could not write snapshot: %w", err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("could not write snapshot: %w", err)
+	}
+	return nil
+}
+
+// restoreSnapshot renders the persisted snapshot, if there is one no
+// older than the window. A missing or corrupt snapshot is ignored.
+func (m *Module) restoreSnapshot() {
+	snap, err := loadSnapshot(filepath.Join(m.path, m.cfg.SnapshotFile))
+	if err != nil {
+		m.log.Info("Ignoring IoTaWatt snapshot", m.logFields("restoreSnapshot", "error", err)...)
+		return
+	}
+	if snap == nil || snap.Current == nil || time.Since(snap.Time) > m.cfg.span() {
+		return
+	}
+
+	if err = m.renderCurrent(*snap.Current); err != nil {
+		m.log.Error("Could not update current", m.logFields("restoreSnapshot", "error", err)...)
+	}
+	if !m.cfg.chart() {
+		return
+	}
+	b, err := json.Marshal(snap.Series)
+	if err != nil {
+		m.log.Error("could not encode data", m.logFields("restoreSnapshot", "error", err)...)
+		return
+	}
+	// The chart is loaded with the series once Highcharts is ready.
+	if _, err = m.eval("%s = %s; %s()", m.jsName(m.cfg.SeriesVariable), string(b), m.jsName(m.cfg.ReloadFunction)); err != nil {
+		m.log.Error("Could not update series", m.logFields("restoreSnapshot", "error", err)...)
+	}
+}
+
+// persistSnapshot saves the latest data at most once per snapshot
+// interval.
+func (m *Module) persistSnapshot() {
+	snap := m.latest.get()
+	if snap == nil || snap.Current == nil || snap.Time.Sub(m.lastSnapshot) < m.cfg.SnapshotInterval {
+		return
+	}
+
+	if err := saveSnapshot(filepath.Join(m.path, m.cfg.SnapshotFile), snap); err != nil {
+		m.log.Error("Could not save snapshot", m.logFields("persistSnapshot", "error", err)...)
+		return
+	}
+	m.lastSnapshot = snap.Time
+}
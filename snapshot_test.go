@@ -0,0 +1,129 @@
+package iotawatt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	current := 1234.5
+	snap := &snapshot{
+		Time:    time.Unix(1614852000, 0),
+		Current: &current,
+		Series:  []Series{{Name: "main", Data: []Point{{1614852000, 1234.5}, {1614852020, nan}}}},
+	}
+
+	err := saveSnapshot(path, snap)
+	require.NoError(t, err)
+	got, err := loadSnapshot(path)
+
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.True(t, snap.Time.Equal(got.Time))
+	require.NotNil(t, got.Current)
+	assert.Equal(t, current, *got.Current)
+	require.Len(t, got.Series, 1)
+	assert.Equal(t, "main", got.Series[0].Name)
+	assertPoints(t, snap.Series[0].Data, got.Series[0].Data)
+}
+
+func TestLoadSnapshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "missing file",
+			wantErr: require.NoError,
+		},
+		{
+			name:    "corrupt file",
+			body:    `{"time":`,
+			wantErr: require.Error,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "snapshot.json")
+			if test.body != "" {
+				err := os.WriteFile(path, []byte(test.body), 0o600)
+				require.NoError(t, err)
+			}
+
+			got, err := loadSnapshot(path)
+
+			test.wantErr(t, err)
+			assert.Nil(t, got)
+		})
+	}
+}
+
+func TestModule_RestoreSnapshot(t *testing.T) {
+	cfg := NewConfig()
+	cfg.SnapshotFile = "snapshot.json"
+	m, ui, _ := newTestModule(t, cfg)
+	current := 500.0
+	err := saveSnapshot(filepath.Join(m.path, cfg.SnapshotFile), &snapshot{
+		Time:    time.Now(),
+		Current: &current,
+		Series:  []Series{{Name: "main", Data: []Point{{1614852000, 500}}}},
+	})
+	require.NoError(t, err)
+
+	m.restoreSnapshot()
+
+	assert.True(t, ui.evaluated("0<sel>.5 kW"))
+	assert.True(t, ui.evaluated(`iotaWattSeries_test = [{"name":"main","data":[[1614852000,500]]}]; reloadChart_test()`))
+}
+
+func TestModule_RestoreSnapshotIgnoresStale(t *testing.T) {
+	cfg := NewConfig()
+	cfg.SnapshotFile = "snapshot.json"
+	m, ui, _ := newTestModule(t, cfg)
+	current := 500.0
+	err := saveSnapshot(filepath.Join(m.path, cfg.SnapshotFile), &snapshot{
+		Time:    time.Now().Add(-2 * cfg.span()),
+		Current: &current,
+	})
+	require.NoError(t, err)
+
+	m.restoreSnapshot()
+
+	assert.Empty(t, ui.scripts())
+}
+
+func TestModule_RestoreSnapshotIgnoresCorrupt(t *testing.T) {
+	cfg := NewConfig()
+	cfg.SnapshotFile = "snapshot.json"
+	m, ui, log := newTestModule(t, cfg)
+	err := os.WriteFile(filepath.Join(m.path, cfg.SnapshotFile), []byte("{"), 0o600)
+	require.NoError(t, err)
+
+	m.restoreSnapshot()
+
+	assert.Empty(t, ui.scripts())
+	assert.Equal(t, []string{"Ignoring IoTaWatt snapshot"}, log.infos())
+}
+
+func TestModule_PersistSnapshot(t *testing.T) {
+	cfg := NewConfig()
+	cfg.SnapshotFile = "snapshot.json"
+	m, _, _ := newTestModule(t, cfg)
+	m.latest.set(500, []Series{{Name: "main", Data: []Point{{1614852000, 500}}}})
+
+	m.persistSnapshot()
+
+	got, err := loadSnapshot(filepath.Join(m.path, cfg.SnapshotFile))
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	require.NotNil(t, got.Current)
+	assert.Equal(t, 500.0, *got.Current)
+}
@@ -0,0 +1,117 @@
+package iotawatt
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Status is a snapshot of the cumulative module statistics.
+type Status struct {
+	// Since is when the statistics were started or last reset.
+	Since time.Time `json:"since"`
+	// Polls is the number of polls made.
+	Polls uint64 `json:"polls"`
+	// Errors is the number of polls that failed.
+	Errors uint64 `json:"errors"`
+	// BytesReceived is the size of the response bodies read.
+	BytesReceived uint64 `json:"bytesReceived"`
+	// WattHour is the energy integrated from the current values.
+	WattHour float64 `json:"wattHour"`
+	// State is the connection state, one of "connected", "degraded"
+	// or "disconnected".
+	State string `json:"state"`
+	// Firmware is the firmware version of the main device, when
+	// detected.
+	Firmware string `json:"firmware,omitempty"`
+}
+
+// metrics holds the cumulative module statistics.
+type metrics struct {
+	mu       sync.Mutex
+	status   Status
+	lastTime float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{status: Status{Since: time.Now(), State: stateConnected}}
+}
+
+func (m *metrics) addPoll(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.Polls++
+	if err != nil {
+		m.status.Errors++
+	}
+}
+
+func (m *metrics) addBytes(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.BytesReceived += uint64(n)
+}
+
+func (m *metrics) addEnergy(ts, watt, maxGap float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.WattHour += integrateEnergy(watt, m.lastTime, ts, maxGap)
+	if ts > m.lastTime {
+		m.lastTime = ts
+	}
+}
+
+func (m *metrics) setState(state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.State = state
+}
+
+func (m *metrics) setFirmware(version string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.Firmware = version
+}
+
+func (m *metrics) get() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.status
+}
+
+func (m *metrics) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The connection state and firmware are current rather than cumulative.
+	m.status = Status{Since: time.Now(), State: m.status.State, Firmware: m.status.Firmware}
+	m.lastTime = 0
+}
+
+// Status returns the cumulative statistics of the module.
+func (m *Module) Status() Status {
+	return m.metrics.get()
+}
+
+// ResetMetrics resets the cumulative statistics of the module.
+func (m *Module) ResetMetrics() {
+	m.metrics.reset()
+}
+
+// countingReader counts the bytes read from the reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
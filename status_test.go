@@ -0,0 +1,48 @@
+package iotawatt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_AddEnergy(t *testing.T) {
+	tests := []struct {
+		name string
+		ts   []float64
+		want float64
+	}{
+		{
+			name: "first reading",
+			ts:   []float64{1614852000},
+			want: 0,
+		},
+		{
+			name: "readings",
+			ts:   []float64{1614852000, 1614852060, 1614852120},
+			want: 1000.0 / 30,
+		},
+		{
+			name: "gap",
+			ts:   []float64{1614852000, 1614852000 + 86400},
+			want: 1000.0 / 60,
+		},
+		{
+			name: "older reading",
+			ts:   []float64{1614852060, 1614852000},
+			want: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newMetrics()
+
+			for _, ts := range test.ts {
+				m.addEnergy(ts, 1000, 60)
+			}
+
+			assert.InDelta(t, test.want, m.status.WattHour, 1e-9)
+		})
+	}
+}
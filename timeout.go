@@ -0,0 +1,73 @@
+package iotawatt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// adaptiveTimeout is a request timeout doubled up to a cap once a
+// number of consecutive requests timed out, and halved back towards
+// the base on each successful request.
+type adaptiveTimeout struct {
+	mu      sync.Mutex
+	base    time.Duration
+	max     time.Duration
+	after   int
+	current time.Duration
+	// timeouts is the number of consecutive timed out requests.
+	timeouts int
+}
+
+func newAdaptiveTimeout(base, max time.Duration, after int) *adaptiveTimeout {
+	return &adaptiveTimeout{base: base, max: max, after: after, current: base}
+}
+
+// get returns the current request timeout.
+func (t *adaptiveTimeout) get() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.current
+}
+
+// record adjusts the timeout after a request, returning the new
+// timeout and whether it changed. Any other error ends a run of
+// timeouts without changing the timeout.
+func (t *adaptiveTimeout) record(err error) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev := t.current
+	switch {
+	case err == nil:
+		t.timeouts = 0
+		t.current /= 2
+		if t.current < t.base {
+			t.current = t.base
+		}
+	case isTimeout(err):
+		t.timeouts++
+		if t.timeouts < t.after {
+			break
+		}
+		t.current *= 2
+		if t.current > t.max {
+			t.current = t.max
+		}
+	default:
+		t.timeouts = 0
+	}
+	return t.current, t.current != prev
+}
+
+// isTimeout determines if the error is caused by a timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var nErr net.Error
+	return errors.As(err, &nErr) && nErr.Timeout()
+}
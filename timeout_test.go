@@ -0,0 +1,186 @@
+package iotawatt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errTimedOut = fmt.Errorf("could not request: %w", context.DeadlineExceeded)
+
+func TestAdaptiveTimeout(t *testing.T) {
+	to := newAdaptiveTimeout(time.Second, 5*time.Second, 1)
+	assert.Equal(t, time.Second, to.get())
+
+	got, changed := to.record(errTimedOut)
+	assert.Equal(t, 2*time.Second, got)
+	assert.True(t, changed)
+
+	got, _ = to.record(errTimedOut)
+	assert.Equal(t, 4*time.Second, got)
+
+	got, changed = to.record(errTimedOut)
+	assert.Equal(t, 5*time.Second, got)
+	assert.True(t, changed)
+
+	got, changed = to.record(errTimedOut)
+	assert.Equal(t, 5*time.Second, got)
+	assert.False(t, changed)
+
+	got, changed = to.record(errors.New("test"))
+	assert.Equal(t, 5*time.Second, got)
+	assert.False(t, changed)
+
+	got, _ = to.record(nil)
+	assert.Equal(t, 2500*time.Millisecond, got)
+
+	to.record(nil)
+	got, changed = to.record(nil)
+	assert.Equal(t, time.Second, got)
+	assert.True(t, changed)
+
+	got, changed = to.record(nil)
+	assert.Equal(t, time.Second, got)
+	assert.False(t, changed)
+	assert.Equal(t, time.Second, to.get())
+}
+
+func TestAdaptiveTimeout_EscalatesAfterConsecutiveTimeouts(t *testing.T) {
+	tests := []struct {
+		name string
+		errs []error
+		want time.Duration
+	}{
+		{
+			name: "single timeout",
+			errs: []error{errTimedOut},
+			want: time.Second,
+		},
+		{
+			name: "consecutive timeouts",
+			errs: []error{errTimedOut, errTimedOut},
+			want: 2 * time.Second,
+		},
+		{
+			name: "further timeouts",
+			errs: []error{errTimedOut, errTimedOut, errTimedOut},
+			want: 4 * time.Second,
+		},
+		{
+			name: "success resets the count",
+			errs: []error{errTimedOut, nil, errTimedOut},
+			want: time.Second,
+		},
+		{
+			name: "other error resets the count",
+			errs: []error{errTimedOut, errors.New("test"), errTimedOut},
+			want: time.Second,
+		},
+		{
+			name: "success after escalation resets the count",
+			errs: []error{errTimedOut, errTimedOut, nil, errTimedOut},
+			want: time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			to := newAdaptiveTimeout(time.Second, 10*time.Second, 2)
+
+			var got time.Duration
+			for _, err := range test.errs {
+				got, _ = to.record(err)
+			}
+
+			assert.Equal(t, test.want, got)
+			assert.Equal(t, test.want, to.get())
+		})
+	}
+}
+
+func TestIsTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "deadline exceeded",
+			err:  errTimedOut,
+			want: true,
+		},
+		{
+			name: "http client timeout",
+			err:  &timeoutError{},
+			want: true,
+		},
+		{
+			name: "other error",
+			err:  errors.New("test"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := isTimeout(test.err)
+
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestModule_RequestEscalatesTimeoutOnRepeatedTimeouts(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.RequestTimeout = 10 * time.Millisecond
+	cfg.MaxRequestTimeout = 30 * time.Millisecond
+	m, _, log := newTestModule(t, cfg)
+	m.timeout = newAdaptiveTimeout(cfg.RequestTimeout, cfg.MaxRequestTimeout, cfg.TimeoutEscalationAfter)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	var got []time.Duration
+	for i := 0; i < 4; i++ {
+		_, err = m.request(context.Background(), u, url.Values{})
+		require.Error(t, err)
+		got = append(got, m.timeout.get())
+	}
+
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond}
+	assert.Equal(t, want, got)
+	assert.Equal(t, 2, countString(log.infos(), "Adjusted IoTaWatt request timeout"))
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func countString(s []string, want string) int {
+	var n int
+	for _, v := range s {
+		if v == want {
+			n++
+		}
+	}
+	return n
+}
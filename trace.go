@@ -0,0 +1,43 @@
+package iotawatt
+
+import "context"
+
+// Tracer starts spans around the requests made to the devices,
+// allowing any tracing library, such as OpenTelemetry, to be used.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute sets an attribute on the span.
+	SetAttribute(key string, value interface{})
+	// RecordError records an error on the span.
+	RecordError(err error)
+	// End ends the span.
+	End()
+}
+
+// Option configures the module.
+type Option func(*Module)
+
+// WithTracer sets the tracer used to trace device requests.
+func WithTracer(t Tracer) Option {
+	return func(m *Module) {
+		m.tracer = t
+	}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+
+func (noopSpan) RecordError(error) {}
+
+func (noopSpan) End() {}
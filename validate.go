@@ -0,0 +1,48 @@
+package iotawatt
+
+import (
+	"math"
+	"time"
+)
+
+// Reading is the latest polled data, passed to a validator.
+type Reading struct {
+	// Time is the time of the latest row.
+	Time time.Time
+	// Current is the current total in watts, or NaN when missing.
+	Current float64
+	// Inputs are the latest values of the inputs by name, NaN
+	// where missing.
+	Inputs map[string]float64
+}
+
+// Validator checks a reading before it is displayed, returning an
+// error to reject it.
+type Validator func(Reading) error
+
+// WithValidator sets a validator checking each reading before it is
+// displayed, e.g. to reject a total above the service capacity. The
+// display is not updated for a rejected reading.
+func WithValidator(v Validator) Option {
+	return func(m *Module) {
+		m.validator = v
+	}
+}
+
+// newReading returns the reading of the latest row.
+func (m *Module) newReading(raw [][]float64, total float64) Reading {
+	r := Reading{Current: total, Inputs: make(map[string]float64, len(m.inputs))}
+	if len(raw) == 0 {
+		for _, in := range m.inputs {
+			r.Inputs[in.Name] = math.NaN()
+		}
+		return r
+	}
+
+	row := raw[len(raw)-1]
+	r.Time = time.Unix(int64(row[0]), 0)
+	for i, in := range m.inputs {
+		r.Inputs[in.Name] = row[i+1]
+	}
+	return r
+}
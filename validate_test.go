@@ -0,0 +1,117 @@
+package iotawatt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModule_PollValidator(t *testing.T) {
+	ts := time.Now().Unix() / 20 * 20
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,30000,-500]]`, ts)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		validator  Validator
+		wantRender bool
+		wantErrs   []string
+	}{
+		{
+			name:       "no validator",
+			wantRender: true,
+		},
+		{
+			name:       "accepts",
+			validator:  func(Reading) error { return nil },
+			wantRender: true,
+		},
+		{
+			name: "rejects above capacity",
+			validator: func(r Reading) error {
+				if r.Current > 25000 {
+					return errors.New("total above service capacity")
+				}
+				return nil
+			},
+			wantErrs: []string{"IoTaWatt data rejected, not updating display"},
+		},
+		{
+			name: "rejects solar production",
+			validator: func(r Reading) error {
+				if r.Inputs["solar"] < 0 {
+					return errors.New("solar producing at night")
+				}
+				return nil
+			},
+			wantErrs: []string{"IoTaWatt data rejected, not updating display"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.URL = srv.URL
+			cfg.Inputs = []Input{{Name: "mains"}, {Name: "solar"}}
+			m, ui, log := newTestModule(t, cfg)
+			WithValidator(test.validator)(m)
+
+			err := m.poll(context.Background())
+
+			require.NoError(t, err)
+			assert.Equal(t, test.wantRender, ui.evaluated(".current"))
+			assert.Equal(t, test.wantRender, m.latest.get() != nil)
+			assert.Equal(t, test.wantErrs, log.errors())
+		})
+	}
+}
+
+func TestModule_PollValidatorReceivesReading(t *testing.T) {
+	ts := time.Now().Unix() / 20 * 20
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,1000,null]]`, ts)
+	}))
+	defer srv.Close()
+	cfg := NewConfig()
+	cfg.URL = srv.URL
+	cfg.Inputs = []Input{{Name: "mains"}, {Name: "solar"}}
+	m, _, _ := newTestModule(t, cfg)
+	var got []Reading
+	WithValidator(func(r Reading) error {
+		got = append(got, r)
+		return nil
+	})(m)
+
+	err := m.poll(context.Background())
+
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, time.Unix(ts, 0), got[0].Time)
+	assert.Equal(t, 1000.0, got[0].Current)
+	assert.Equal(t, 1000.0, got[0].Inputs["mains"])
+	assert.True(t, math.IsNaN(got[0].Inputs["solar"]))
+}
+
+func TestModule_NewReadingWithoutRows(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Inputs = []Input{{Name: "mains"}}
+	m, _, _ := newTestModule(t, cfg)
+
+	got := m.newReading(nil, math.NaN())
+
+	assert.True(t, got.Time.IsZero())
+	assert.True(t, math.IsNaN(got.Current))
+	assert.True(t, math.IsNaN(got.Inputs["mains"]))
+}
@@ -0,0 +1,155 @@
+package iotawatt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// visibility is the visibility of the inputs set from the ui, keyed by
+// input name. Inputs without a set visibility are visible.
+type visibility struct {
+	mu sync.Mutex
+
+	path    string
+	visible map[string]bool
+}
+
+// newVisibility returns a visibility with every input visible, persisted
+// in the file at path. Nothing is persisted if path is empty.
+func newVisibility(path string) *visibility {
+	return &visibility{path: path, visible: map[string]bool{}}
+}
+
+// loadVisibility loads the visibility from the file at path. Nothing is
+// persisted if path is empty.
+func loadVisibility(path string) (*visibility, error) {
+	v := newVisibility(path)
+	if path == "" {
+		return v, nil
+	}
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	switch {
+	case os.IsNotExist(err):
+		return v, nil
+	case err != nil:
+		return nil, fmt.Errorf("could not read visibility: %w", err)
+	}
+	if err = json.Unmarshal(b, &v.visible); err != nil {
+		return nil, fmt.Errorf("could not parse visibility: %w", err)
+	}
+	return v, nil
+}
+
+// get returns the visibility of the input, and whether it was set.
+func (v *visibility) get(name string) (visible, ok bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	visible, ok = v.visible[name]
+	return visible || !ok, ok
+}
+
+// set sets the visibility of the input, saving it if persisted.
+func (v *visibility) set(name string, visible bool) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.visible[name] = visible
+	if v.path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(v.visible)
+	if err != nil {
+		return fmt.Errorf("could not encode visibility: %w", err)
+	}
+	tmp := v.path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("could not write visibility: %w", err)
+	}
+	if err = os.Rename(tmp, v.path); err != nil {
+		return fmt.Errorf("could not write visibility: %w", err)
+	}
+	return nil
+}
+
+// hiddenCols returns the response columns of the hidden inputs.
+func (v *visibility) hiddenCols(inputs []Input) map[int]bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	cols := map[int]bool{}
+	for i, in := range inputs {
+		if visible, ok := v.visible[in.Name]; ok && !visible {
+			cols[i+1] = true
+		}
+	}
+	return cols
+}
+
+// SetInputVisible shows or hides the input in the chart. The visibility
+// is persisted and applied from the next poll.
+func (m *Module) SetInputVisible(name string, visible bool) error {
+	if !m.hasInput(name) {
+		return fmt.Errorf("iotawatt: %q is not a configured input", name)
+	}
+	if err := m.visibility.set(name, visible); err != nil {
+		return fmt.Errorf("iotawatt: %w", err)
+	}
+	return nil
+}
+
+// InputVisibility returns the visibility of each input, keyed by input name.
+func (m *Module) InputVisibility() map[string]bool {
+	res := make(map[string]bool, len(m.inputs))
+	for _, in := range m.inputs {
+		res[in.Name], _ = m.visibility.get(in.Name)
+	}
+	return res
+}
+
+func (m *Module) hasInput(name string) bool {
+	for _, in := range m.inputs {
+		if in.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// toggleLegend sets the visibility of the input with the legend name,
+// when its legend item is tapped. Other series are only toggled in the
+// chart.
+func (m *Module) toggleLegend(legend string, visible bool) error {
+	for _, in := range m.inputs {
+		if in.legendName() != legend {
+			continue
+		}
+		if err := m.SetInputVisible(in.Name, visible); err != nil {
+			m.log.Error("Could not set input visibility", m.logFields("toggleLegend", "input", in.Name, "error", err)...)
+			return err
+		}
+		return nil
+	}
+	return nil
+}
+
+// visibleTotals returns the configured total columns without those of
+// hidden inputs.
+func (m *Module) visibleTotals() []int {
+	hidden := m.visibility.hiddenCols(m.inputs)
+	if len(hidden) == 0 {
+		return m.allTotals
+	}
+	totals := make([]int, 0, len(m.allTotals))
+	for _, col := range m.allTotals {
+		if !hidden[col] {
+			totals = append(totals, col)
+		}
+	}
+	return totals
+}
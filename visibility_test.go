@@ -0,0 +1,220 @@
+package iotawatt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glasslabs/looking-glass/module/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadVisibility(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	require.NoError(t, os.WriteFile(valid, []byte(`{"solar":false,"mains":true}`), 0o600))
+	corrupt := filepath.Join(dir, "corrupt.json")
+	require.NoError(t, os.WriteFile(corrupt, []byte(`{"solar":fal`), 0o600))
+
+	tests := []struct {
+		name    string
+		path    string
+		want    map[string]bool
+		wantErr require.ErrorAssertionFunc
+	}{
+		{
+			name:    "in memory",
+			want:    map[string]bool{},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "missing file",
+			path:    filepath.Join(dir, "missing.json"),
+			want:    map[string]bool{},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "valid file",
+			path:    valid,
+			want:    map[string]bool{"solar": false, "mains": true},
+			wantErr: require.NoError,
+		},
+		{
+			name:    "corrupt file",
+			path:    corrupt,
+			wantErr: require.Error,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := loadVisibility(test.path)
+
+			test.wantErr(t, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, test.want, got.visible)
+		})
+	}
+}
+
+func TestVisibility_SetPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "visibility.json")
+	v := newVisibility(path)
+
+	require.NoError(t, v.set("solar", false))
+	require.NoError(t, v.set("mains", true))
+
+	got, err := loadVisibility(path)
+	require.NoError(t, err)
+	visible, ok := got.get("solar")
+	assert.False(t, visible)
+	assert.True(t, ok)
+	visible, ok = got.get("mains")
+	assert.True(t, visible)
+	assert.True(t, ok)
+	visible, ok = got.get("other")
+	assert.True(t, visible)
+	assert.False(t, ok)
+	assert.NoFileExists(t, path+".tmp")
+}
+
+func TestVisibility_HiddenCols(t *testing.T) {
+	v := newVisibility("")
+	require.NoError(t, v.set("l1", true))
+	require.NoError(t, v.set("l2", false))
+
+	got := v.hiddenCols([]Input{{Name: "l1"}, {Name: "l2"}, {Name: "l3"}})
+
+	assert.Equal(t, map[int]bool{2: true}, got)
+}
+
+func TestModule_SetInputVisible(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Inputs = []Input{{Name: "mains"}, {Name: "solar"}}
+	m, _, _ := newTestModule(t, cfg)
+	m.visibility = newVisibility(filepath.Join(t.TempDir(), "visibility.json"))
+
+	err := m.SetInputVisible("solar", false)
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"mains": true, "solar": false}, m.InputVisibility())
+	assert.FileExists(t, m.visibility.path)
+
+	err = m.SetInputVisible("other", false)
+
+	assert.Error(t, err)
+	assert.Equal(t, map[string]bool{"mains": true, "solar": false}, m.InputVisibility())
+}
+
+func TestModule_ToggleLegend(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Inputs = []Input{{Name: "mains", Unit: "watts"}, {Name: "solar", Label: "Solar"}}
+	m, _, _ := newTestModule(t, cfg)
+
+	require.NoError(t, m.toggleLegend("mains (W)", false))
+	require.NoError(t, m.toggleLegend("Solar", false))
+	require.NoError(t, m.toggleLegend("Combined", false))
+
+	assert.Equal(t, map[string]bool{"mains": false, "solar": false}, m.InputVisibility())
+}
+
+func TestModule_PollFiltersHiddenInputs(t *testing.T) {
+	ts := time.Now().Unix() / 20 * 20
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(rw, `[[%d,50,30]]`, ts)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name          string
+		excludeHidden bool
+		wantCurrent   string
+	}{
+		{
+			name:        "hidden included in current",
+			wantCurrent: "80<sel>.0 W",
+		},
+		{
+			name:          "hidden excluded from current",
+			excludeHidden: true,
+			wantCurrent:   "50<sel>.0 W",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.URL = srv.URL
+			cfg.Inputs = []Input{{Name: "mains"}, {Name: "solar"}}
+			cfg.ExcludeHidden = test.excludeHidden
+			m, ui, _ := newTestModule(t, cfg)
+			require.NoError(t, m.SetInputVisible("solar", false))
+
+			err := m.poll(context.Background())
+
+			require.NoError(t, err)
+			want := fmt.Sprintf(`[{"name":"mains","data":[[%[1]d,50]]},{"name":"solar","data":[[%[1]d,30]],"visible":false}]`, ts)
+			assert.True(t, ui.evaluated(want))
+			assert.True(t, ui.evaluated(test.wantCurrent))
+		})
+	}
+}
+
+func TestNew_LoadsVisibility(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		want     map[string]bool
+		wantInfo bool
+	}{
+		{
+			name: "persisted",
+			file: `{"solar":false}`,
+			want: map[string]bool{"mains": true, "solar": false},
+		},
+		{
+			name:     "corrupt file is ignored",
+			file:     `{"solar":`,
+			want:     map[string]bool{"mains": true, "solar": true},
+			wantInfo: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				rw.Header().Set("Content-Type", "application/json")
+				_, _ = fmt.Fprintf(rw, `[[%d,50,30]]`, time.Now().Unix()/20*20)
+			}))
+			defer srv.Close()
+			path := filepath.Join(t.TempDir(), "visibility.json")
+			require.NoError(t, os.WriteFile(path, []byte(test.file), 0o600))
+			// The file is relative to the module path, which holds the assets.
+			wd, err := os.Getwd()
+			require.NoError(t, err)
+			rel, err := filepath.Rel(wd, path)
+			require.NoError(t, err)
+			cfg := NewConfig()
+			cfg.URL = srv.URL
+			cfg.Inputs = []Input{{Name: "mains"}, {Name: "solar"}}
+			cfg.VisibilityFile = rel
+			log := &testLogger{}
+
+			mod, err := New(context.Background(), cfg, types.Info{Name: "test", Path: ".", Log: log}, &testUI{})
+
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = mod.Close() })
+			assert.Equal(t, test.want, mod.(*Module).InputVisibility())
+			assert.Equal(t, test.wantInfo, countString(log.infos(), "Ignoring IoTaWatt visibility, showing all inputs") > 0)
+		})
+	}
+}